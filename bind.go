@@ -0,0 +1,472 @@
+package rest
+
+import (
+  "fmt"
+  "time"
+  "bytes"
+  "reflect"
+  "strconv"
+  "strings"
+  "encoding"
+  "io/ioutil"
+  "net/http"
+  "encoding/json"
+)
+
+import (
+  "github.com/gorilla/mux"
+)
+
+/**
+ * The maximum memory used to parse multipart forms when binding
+ */
+const maxBindMemory = 32 << 20
+
+/**
+ * Binding sources, in the order they are applied
+ */
+var bindSources = []string{"path", "query", "header", "form"}
+
+var (
+  typeTime            = reflect.TypeOf(time.Time{})
+  typeDuration        = reflect.TypeOf(time.Duration(0))
+  typeUUID            = reflect.TypeOf(UUID{})
+  typeTextUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+/**
+ * A field that could not be bound or is invalid
+ */
+type FieldError struct {
+  Field   string  `json:"field"`
+  Source  string  `json:"source,omitempty"`
+  Message string  `json:"message"`
+}
+
+/**
+ * It's an error
+ */
+func (e FieldError) Error() string {
+  if e.Field == "" {
+    return fmt.Sprintf("%s: %s", e.Source, e.Message)
+  }else if e.Source != "" {
+    return fmt.Sprintf("%s %s: %s", e.Source, e.Field, e.Message)
+  }else{
+    return fmt.Sprintf("%s: %s", e.Field, e.Message)
+  }
+}
+
+/**
+ * A set of field errors
+ */
+type FieldErrors []FieldError
+
+/**
+ * It's also an error
+ */
+func (e FieldErrors) Error() string {
+  s := make([]string, len(e))
+  for i, f := range e {
+    s[i] = f.Error()
+  }
+  return strings.Join(s, "; ")
+}
+
+/**
+ * Bind a request to the struct pointed to by dst. Fields are bound from
+ * the request according to their tags:
+ *
+ *   path:"name"    a route variable
+ *   query:"name"   a query parameter
+ *   header:"name"  a request header
+ *   json:"name"    a field in a JSON request entity
+ *   form:"name"    a field in a URL-encoded or multipart request entity
+ *
+ * Exported fields with none of these tags are decoded from a JSON entity
+ * under their own names, as they are by json.Unmarshal.
+ *
+ * A default:"value" tag provides a value for a field which no source,
+ * including the entity, provides, and a format:"layout" tag provides the
+ * layout used to parse times, which are otherwise expected in RFC 3339
+ * format. Strings, booleans, numbers, times, durations, UUIDs,
+ * encoding.TextUnmarshalers, and pointers and slices of these are
+ * supported. Slices are bound from repeated or comma-separated values.
+ *
+ * The entity is bound first, so parameters take precedence over entity
+ * fields of the same name. Fields which are bound from parameters should
+ * generally be tagged json:"-".
+ *
+ * If any field cannot be bound an *Error is returned whose Detail is the
+ * FieldErrors describing every field that failed. The status is 400 if any
+ * parameter or the entity itself was malformed, otherwise 422.
+ */
+func Bind(req *Request, dst interface{}) error {
+  v := reflect.ValueOf(dst)
+  if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+    return fmt.Errorf("Bind destination must be a non-nil pointer to a struct: %T", dst)
+  }
+
+  b := &binder{req:req}
+  if err := b.bindEntity(v.Elem(), dst); err != nil {
+    return err
+  }
+  b.bindFields(v.Elem())
+
+  if len(b.errs) > 0 {
+    return NewErrorf(b.status, "Invalid request: %v", b.errs).SetDetail(b.errs)
+  }
+  return nil
+}
+
+/**
+ * Binding state
+ */
+type binder struct {
+  req     *Request
+  vars    map[string]string
+  query   map[string][]string
+  form    map[string][]string
+  entity  map[string]bool // the fields the JSON entity provided, lowercased
+  errs    FieldErrors
+  status  int
+}
+
+/**
+ * Note a field error
+ */
+func (b *binder) fail(status int, src, field, msg string) {
+  b.errs = append(b.errs, FieldError{field, src, msg})
+  if b.status == 0 || status == http.StatusBadRequest {
+    b.status = status
+  }
+}
+
+/**
+ * Bind the request entity
+ */
+func (b *binder) bindEntity(v reflect.Value, dst interface{}) error {
+  req := b.req
+  if req.Body == nil || req.Body == http.NoBody {
+    return nil
+  }
+
+  ctype := strings.ToLower(req.Header.Get("Content-Type"))
+  if x := strings.Index(ctype, ";"); x >= 0 {
+    ctype = strings.TrimSpace(ctype[:x])
+  }
+
+  switch {
+    case ctype == "application/x-www-form-urlencoded" || ctype == "multipart/form-data":
+      if !hasTag(v.Type(), "form") {
+        return nil
+      }
+      if err := req.ParseMultipartForm(maxBindMemory); err != nil && err != http.ErrNotMultipart {
+        if rerr, ok := err.(*Error); ok {
+          return rerr
+        }
+        return NewErrorf(http.StatusBadRequest, "Could not parse request form: %v", err)
+      }
+      b.form = make(map[string][]string)
+      for k, e := range req.PostForm {
+        b.form[k] = e
+      }
+      if m := req.MultipartForm; m != nil {
+        for k, e := range m.Value {
+          b.form[k] = e
+        }
+      }
+
+    case ctype == "" || ctype == CONTENT_TYPE_JSON || strings.HasSuffix(ctype, "+json"):
+      if !hasEntityFields(v.Type()) {
+        return nil
+      }
+      data, err := ioutil.ReadAll(req.Body)
+      if rerr, ok := err.(*Error); ok {
+        return rerr
+      }else if err != nil {
+        return NewErrorf(http.StatusBadRequest, "Could not read request entity: %v", err)
+      }
+      if err := req.VerifyEntityDigest(data); err != nil {
+        return err
+      }
+      req.Body = ioutil.NopCloser(bytes.NewReader(data)) // leave the entity available to handlers
+      if len(bytes.TrimSpace(data)) == 0 {
+        return nil
+      }
+      err = json.Unmarshal(data, dst)
+      if terr, ok := err.(*json.UnmarshalTypeError); ok {
+        b.fail(http.StatusUnprocessableEntity, "body", terr.Field, fmt.Sprintf("expected %v, got %v", terr.Type, terr.Value))
+      }else if err != nil {
+        return NewErrorf(http.StatusBadRequest, "Could not unmarshal request entity: %v", err)
+      }
+      var fields map[string]json.RawMessage
+      if json.Unmarshal(data, &fields) == nil {
+        b.entity = make(map[string]bool)
+        for k, _ := range fields {
+          b.entity[strings.ToLower(k)] = true // field names are matched without regard to case, as they are when decoding
+        }
+      }
+
+  }
+
+  return nil
+}
+
+/**
+ * Bind tagged fields from request parameters
+ */
+func (b *binder) bindFields(v reflect.Value) {
+  t := v.Type()
+  for i := 0; i < t.NumField(); i++ {
+    f := t.Field(i)
+    if f.PkgPath != "" && !f.Anonymous {
+      continue // unexported
+    }
+
+    fv := v.Field(i)
+    if f.Anonymous && f.Type.Kind() == reflect.Struct && !hasAnyTag(f) {
+      b.bindFields(fv)
+      continue
+    }
+
+    bound := false
+    for _, src := range bindSources {
+      name := f.Tag.Get(src)
+      if name == "" || name == "-" {
+        continue
+      }
+      vals, ok := b.values(src, name)
+      if !ok {
+        continue
+      }
+      if err := setValue(fv, vals, f.Tag.Get("format")); err != nil {
+        b.fail(http.StatusBadRequest, src, name, err.Error())
+      }
+      bound = true
+      break
+    }
+
+    // defaults apply to fields no source provided, including the entity
+    if d, ok := f.Tag.Lookup("default"); ok && !bound && !b.inEntity(f) {
+      if err := setValue(fv, []string{d}, f.Tag.Get("format")); err != nil {
+        b.fail(http.StatusBadRequest, "default", f.Name, err.Error())
+      }
+    }
+  }
+}
+
+/**
+ * Determine if the JSON entity provided a field
+ */
+func (b *binder) inEntity(f reflect.StructField) bool {
+  if b.entity == nil {
+    return false
+  }
+  name, ok := f.Tag.Lookup("json")
+  if !ok && hasAnyTag(f) {
+    return false
+  }
+  if x := strings.Index(name, ","); x >= 0 {
+    name = name[:x]
+  }
+  if name == "-" {
+    return false
+  }else if name == "" {
+    name = f.Name
+  }
+  return b.entity[strings.ToLower(name)]
+}
+
+/**
+ * Obtain values for a field from a source
+ */
+func (b *binder) values(src, name string) ([]string, bool) {
+  switch src {
+    case "path":
+      if b.vars == nil {
+        b.vars = mux.Vars(b.req.Request)
+      }
+      v, ok := b.vars[name]
+      if !ok {
+        return nil, false
+      }
+      return []string{v}, true
+    case "query":
+      if b.query == nil {
+        b.query = b.req.URL.Query()
+      }
+      v, ok := b.query[name]
+      return v, ok && len(v) > 0
+    case "header":
+      v := b.req.Header.Values(name)
+      return v, len(v) > 0
+    case "form":
+      v, ok := b.form[name]
+      return v, ok && len(v) > 0
+    default:
+      return nil, false
+  }
+}
+
+/**
+ * Determine if a struct type has a field with the specified tag
+ */
+func hasTag(t reflect.Type, tag string) bool {
+  for i := 0; i < t.NumField(); i++ {
+    f := t.Field(i)
+    if _, ok := f.Tag.Lookup(tag); ok {
+      return true
+    }
+    if f.Anonymous && f.Type.Kind() == reflect.Struct && hasTag(f.Type, tag) {
+      return true
+    }
+  }
+  return false
+}
+
+/**
+ * Determine if a struct type has fields decoded from a JSON entity: those
+ * tagged for it and, as when unmarshaling, exported fields without a tag
+ * for any other source
+ */
+func hasEntityFields(t reflect.Type) bool {
+  for i := 0; i < t.NumField(); i++ {
+    f := t.Field(i)
+    if f.PkgPath != "" && !f.Anonymous {
+      continue // unexported
+    }
+    if n, ok := f.Tag.Lookup("json"); ok {
+      if n != "-" {
+        return true
+      }
+      continue
+    }
+    if f.Anonymous && f.Type.Kind() == reflect.Struct {
+      if !hasAnyTag(f) && hasEntityFields(f.Type) {
+        return true
+      }
+      continue
+    }
+    if f.PkgPath == "" && !hasAnyTag(f) {
+      return true
+    }
+  }
+  return false
+}
+
+/**
+ * Determine if a field has any binding tag
+ */
+func hasAnyTag(f reflect.StructField) bool {
+  for _, e := range bindSources {
+    if _, ok := f.Tag.Lookup(e); ok {
+      return true
+    }
+  }
+  _, ok := f.Tag.Lookup("json")
+  return ok
+}
+
+/**
+ * Set a value from its string representations
+ */
+func setValue(v reflect.Value, vals []string, format string) error {
+  if len(vals) == 0 {
+    return nil
+  }
+
+  switch {
+    case v.Kind() == reflect.Ptr:
+      n := reflect.New(v.Type().Elem())
+      if err := setValue(n.Elem(), vals, format); err != nil {
+        return err
+      }
+      v.Set(n)
+      return nil
+
+    case v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8:
+      if len(vals) == 1 {
+        vals = strings.Split(vals[0], ",")
+      }
+      s := reflect.MakeSlice(v.Type(), len(vals), len(vals))
+      for i, e := range vals {
+        if err := setScalar(s.Index(i), strings.TrimSpace(e), format); err != nil {
+          return err
+        }
+      }
+      v.Set(s)
+      return nil
+
+    default:
+      return setScalar(v, vals[0], format)
+  }
+}
+
+/**
+ * Set a scalar value from its string representation
+ */
+func setScalar(v reflect.Value, s string, format string) error {
+  t := v.Type()
+
+  switch {
+    case t == typeTime:
+      if format == "" {
+        format = time.RFC3339Nano
+      }
+      x, err := time.Parse(format, s)
+      if err != nil {
+        return fmt.Errorf("invalid time: %q", s)
+      }
+      v.Set(reflect.ValueOf(x))
+      return nil
+    case t == typeDuration:
+      x, err := time.ParseDuration(s)
+      if err != nil {
+        return fmt.Errorf("invalid duration: %q", s)
+      }
+      v.SetInt(int64(x))
+      return nil
+    case t == typeUUID:
+      x, err := ParseUUID(s)
+      if err != nil {
+        return fmt.Errorf("invalid UUID: %q", s)
+      }
+      v.Set(reflect.ValueOf(x))
+      return nil
+    case reflect.PtrTo(t).Implements(typeTextUnmarshaler) && v.CanAddr():
+      return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+  }
+
+  switch t.Kind() {
+    case reflect.String:
+      v.SetString(s)
+    case reflect.Bool:
+      x, err := strconv.ParseBool(s)
+      if err != nil {
+        return fmt.Errorf("invalid boolean: %q", s)
+      }
+      v.SetBool(x)
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+      x, err := strconv.ParseInt(s, 10, t.Bits())
+      if err != nil {
+        return fmt.Errorf("invalid integer: %q", s)
+      }
+      v.SetInt(x)
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+      x, err := strconv.ParseUint(s, 10, t.Bits())
+      if err != nil {
+        return fmt.Errorf("invalid unsigned integer: %q", s)
+      }
+      v.SetUint(x)
+    case reflect.Float32, reflect.Float64:
+      x, err := strconv.ParseFloat(s, t.Bits())
+      if err != nil {
+        return fmt.Errorf("invalid number: %q", s)
+      }
+      v.SetFloat(x)
+    default:
+      return fmt.Errorf("unsupported type: %v", t)
+  }
+
+  return nil
+}
@@ -0,0 +1,168 @@
+package rest
+
+import (
+  "io"
+  "strings"
+  "net/http"
+  "compress/gzip"
+  "compress/zlib"
+)
+
+/**
+ * Entity attributes
+ */
+const (
+  ATTR_MAX_ENTITY_SIZE = "rest.entity.max_size"
+)
+
+/**
+ * The default maximum ratio of decoded to encoded bytes we will accept
+ * when decompressing a request entity
+ */
+const defaultMaxInflateRatio = 100
+
+/**
+ * Encoded entities are not checked against the inflate ratio until at
+ * least this many bytes have been decoded, so small, highly compressible
+ * entities are not rejected
+ */
+const minInflateCheck = 1 << 16
+
+/**
+ * Prepare a request entity for consumption by handlers. The entity is
+ * transparently decoded if it has a supported content encoding and reading
+ * it is limited to the maximum entity size in effect for the request.
+ */
+func (s *Service) prepareEntity(req *Request) error {
+  if req.Body == nil || req.Body == http.NoBody {
+    return nil
+  }
+
+  limit := s.maxEntitySize
+  if v, ok := intAttr(req.Attrs, ATTR_MAX_ENTITY_SIZE); ok {
+    limit = v
+  }
+  if limit > 0 && req.ContentLength > limit {
+    return NewErrorf(http.StatusRequestEntityTooLarge, "Request entity is too large: %d bytes exceeds the limit of %d bytes", req.ContentLength, limit)
+  }
+
+  var body io.ReadCloser = req.Body
+  var raw io.Reader = req.Body
+  var count *countingReader
+
+  // entity digests describe the entity before it is decoded
+  enc := strings.ToLower(strings.TrimSpace(req.Header.Get("Content-Encoding")))
+  if enc != "" && enc != "identity" {
+    x, err := newEntityDigest(raw, req.Header)
+    if err != nil {
+      return err
+    }else if x != nil {
+      req.digest, raw = x, x
+    }
+  }
+
+  switch enc {
+    case "", "identity":
+      // nothing to decode
+    case "gzip", "x-gzip":
+      count = &countingReader{Reader:raw}
+      r, err := gzip.NewReader(count)
+      if err != nil {
+        return NewErrorf(http.StatusBadRequest, "Could not decode gzip request entity: %v", err)
+      }
+      body = &decodingReader{r, req.Body}
+    case "deflate":
+      count = &countingReader{Reader:raw}
+      r, err := zlib.NewReader(count)
+      if err != nil {
+        return NewErrorf(http.StatusBadRequest, "Could not decode deflate request entity: %v", err)
+      }
+      body = &decodingReader{r, req.Body}
+    default:
+      return NewErrorf(http.StatusUnsupportedMediaType, "Unsupported request entity content encoding: %v", enc).SetHeaders(map[string]string{"Accept-Encoding": "gzip, deflate, identity"})
+  }
+
+  if count != nil {
+    req.Header.Del("Content-Encoding")
+    req.ContentLength = -1
+  }
+
+  ratio := s.maxInflateRatio
+  if ratio == 0 {
+    ratio = defaultMaxInflateRatio
+  }
+
+  req.Body = &limitedReader{body, limit, 0, count, ratio}
+  return nil
+}
+
+/**
+ * Counts the bytes read from the underlying reader
+ */
+type countingReader struct {
+  io.Reader
+  n int64
+}
+
+/**
+ * Read
+ */
+func (r *countingReader) Read(p []byte) (int, error) {
+  n, err := r.Reader.Read(p)
+  r.n += int64(n)
+  return n, err
+}
+
+/**
+ * A decoding reader closes both the decoder and the original body
+ */
+type decodingReader struct {
+  io.ReadCloser
+  body io.Closer
+}
+
+/**
+ * Close
+ */
+func (r *decodingReader) Close() error {
+  err := r.ReadCloser.Close()
+  if cerr := r.body.Close(); err == nil {
+    err = cerr
+  }
+  return err
+}
+
+/**
+ * A limited reader produces a 413 error when more than the permitted number
+ * of bytes are read or when an encoded entity inflates by too large a ratio
+ */
+type limitedReader struct {
+  io.ReadCloser
+  limit   int64
+  n       int64
+  encoded *countingReader
+  ratio   int
+}
+
+/**
+ * Read
+ */
+func (r *limitedReader) Read(p []byte) (int, error) {
+  if r.limit > 0 {
+    if r.n > r.limit {
+      return 0, NewErrorf(http.StatusRequestEntityTooLarge, "Request entity is too large: exceeds the limit of %d bytes", r.limit)
+    }
+    if int64(len(p)) > r.limit - r.n + 1 {
+      p = p[:r.limit - r.n + 1] // read at most one byte past the limit so we can tell it was exceeded
+    }
+  }
+  n, err := r.ReadCloser.Read(p)
+  r.n += int64(n)
+  if r.limit > 0 && r.n > r.limit {
+    return n, NewErrorf(http.StatusRequestEntityTooLarge, "Request entity is too large: exceeds the limit of %d bytes", r.limit)
+  }
+  if r.encoded != nil && r.ratio > 0 && r.n > minInflateCheck && r.n > r.encoded.n * int64(r.ratio) {
+    return n, NewErrorf(http.StatusRequestEntityTooLarge, "Request entity is too large: decoded entity exceeds %d times its encoded size", r.ratio)
+  }
+  return n, err
+}
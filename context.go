@@ -5,6 +5,7 @@ import (
   "time"
   "bytes"
   "strings"
+  "sync"
   "io/ioutil"
   "net/http"
   "net/http/httptest"
@@ -16,47 +17,188 @@ import (
 )
 
 /**
- * A service context
+ * A service context. Contexts may be nested in groups, in which case a
+ * route's middleware is that of each enclosing context, outermost first,
+ * followed by that of the context in which the route is registered.
  */
 type Context struct {
-  service   *Service
-  router    *mux.Router
-  pipeline  Pipeline
+  service     *Service
+  parent      *Context
+  prefix      string
+  host        *mux.Route // matches the context's host, if it has one
+  router      *mux.Router
+  middleware  []middleware
+  notFound    Handler
+}
+
+/**
+ * Context middleware, which may apply only to routes with certain attributes
+ */
+type middleware struct {
+  cond    Condition
+  handler Handler
+}
+
+/**
+ * A condition on route attributes
+ */
+type Condition func(Attrs) bool
+
+/**
+ * A condition that is true for routes with the specified attribute, unless
+ * its value is false
+ */
+func HasAttr(k string) Condition {
+  return func(a Attrs) bool {
+    v, ok := a[k]
+    if b, isbool := v.(bool); isbool {
+      return b
+    }
+    return ok && v != nil
+  }
+}
+
+/**
+ * A condition that is true for routes without the specified attribute
+ */
+func WithoutAttr(k string) Condition {
+  c := HasAttr(k)
+  return func(a Attrs) bool {
+    return !c(a)
+  }
 }
 
 /**
  * Create a context
  */
-func newContext(s *Service, r *mux.Router) *Context {
-  return &Context{s, r, nil}
+func newContext(s *Service, p string, r *mux.Router) *Context {
+  return &Context{service:s, prefix:p, router:r}
 }
 
 /**
- * Attach a handler to the context pipeline
+ * Create a group: a context scoped under a path relative to this one
+ * whose routes are handled by this context's middleware, followed by the
+ * middleware provided and any attached to the group later.
+ */
+func (c *Context) Group(p string, h ...Handler) *Context {
+  g := newContext(c.service, c.prefix + p, c.router.PathPrefix(p).Subrouter())
+  g.parent = c
+  g.host = c.host
+  g.Use(h...)
+  return g
+}
+
+/**
+ * Attach a handler to the context middleware. Middleware applies to every
+ * route created with HandleFunc or Route in this context and its groups,
+ * including those created before it was attached, in the order it was
+ * attached.
  */
 func (c *Context) Use(h ...Handler) {
-  if h != nil {
-    for _, e := range h {
-      c.pipeline = c.pipeline.Add(e)
+  c.UseIf(nil, h...)
+}
+
+/**
+ * Attach a handler to the context middleware which only applies to routes
+ * whose attributes satisfy a condition
+ */
+func (c *Context) UseIf(cond Condition, h ...Handler) {
+  c.service.middlewareLock.Lock()
+  defer c.service.middlewareLock.Unlock()
+  for _, e := range h {
+    c.middleware = append(c.middleware, middleware{cond, e})
+  }
+  c.service.middlewareGen++
+}
+
+/**
+ * Obtain the middleware that applies to a route with the provided
+ * attributes. The caller must hold the service's middleware lock.
+ */
+func (c *Context) pipelineFor(a Attrs) Pipeline {
+  var p Pipeline
+  if c.parent != nil {
+    p = c.parent.pipelineFor(a)
+  }
+  for _, e := range c.middleware {
+    if e.cond == nil || e.cond(a) {
+      p = p.Add(e.handler)
     }
   }
+  return p
 }
 
 /**
- * Create a route
+ * Create a route whose handler is run through the context middleware
  */
 func (c *Context) HandleFunc(u string, f func(http.ResponseWriter, *Request, Pipeline)(interface{}, error), a ...Attrs) *mux.Route {
-  return c.Handle(u, c.pipeline.Add(HandlerFunc(f)), a...)
+  return c.Route(u, HandlerFunc(f), a...)
+}
+
+/**
+ * Create a route whose handler is run through the context middleware
+ */
+func (c *Context) Route(u string, h Handler, a ...Attrs) *mux.Route {
+  return c.Handle(u, &contextHandler{context:c, attrs:mergeAttrs(a...), handler:h}, a...)
 }
 
 /**
- * Create a route
+ * Create a route whose handler is used as-is, without the context
+ * middleware
  */
 func (c *Context) Handle(u string, h Handler, a ...Attrs) *mux.Route {
   attr := mergeAttrs(a...)
-  return c.router.HandleFunc(u, func(rsp http.ResponseWriter, req *http.Request){
-    c.handle(rsp, newRequestWithAttributes(req, attr), h)
+  r := c.router.HandleFunc(u, func(rsp http.ResponseWriter, req *http.Request){
+    c.handle(rsp, newRequestWithAttributes(c.service, req, mergeAttrs(attr)), h) // copy attributes so requests can't modify the route's
   })
+  if n, ok := attr[ATTR_ROUTE_NAME].(string); ok && n != "" {
+    r.Name(n)
+  }
+  c.service.register(r, attr, h)
+  return r
+}
+
+/**
+ * A handler run through the middleware of a context. The pipeline is
+ * resolved when the first request is handled and again whenever the
+ * middleware changes.
+ */
+type contextHandler struct {
+  context *Context
+  attrs   Attrs
+  handler Handler
+  lock    sync.Mutex
+  pln     Pipeline
+  gen     int
+}
+
+/**
+ * Obtain the pipeline that handles requests
+ */
+func (h *contextHandler) pipeline() Pipeline {
+  s := h.context.service
+  s.middlewareLock.RLock()
+  defer s.middlewareLock.RUnlock()
+  h.lock.Lock()
+  defer h.lock.Unlock()
+  if h.pln == nil || h.gen != s.middlewareGen {
+    p := h.context.pipelineFor(h.attrs)
+    switch v := h.attrs[ATTR_VALIDATORS].(type) {
+      case Validators:
+        p = p.Add(Preconditions(v))
+      case func(*Request)(ETag, time.Time, error):
+        p = p.Add(Preconditions(v))
+    }
+    h.pln, h.gen = p.Add(h.handler), s.middlewareGen
+  }
+  return h.pln
+}
+
+/**
+ * Serve a request
+ */
+func (h *contextHandler) ServeRequest(rsp http.ResponseWriter, req *Request, pln Pipeline) (interface{}, error) {
+  return h.pipeline().Next(rsp, req)
 }
 
 /**
@@ -65,11 +207,9 @@ func (c *Context) Handle(u string, h Handler, a ...Attrs) *mux.Route {
 func (c *Context) handle(rsp http.ResponseWriter, req *Request, h Handler) {
   start := time.Now()
   
-  // deal with proxies
-  if r := req.Header.Get("X-Forwarded-For"); r != "" {
-    req.RemoteAddr = r
-  }else if r = req.Header.Get("X-Origin-IP"); r != "" {
-    req.RemoteAddr = r
+  // note variables captured from the host
+  if _, vars := c.matchesHost(req.Request); len(vars) > 0 {
+    req.putAttributes(Attrs{ATTR_HOST_VARS: vars})
   }
   
   // where is this request endpoint, including parameters
@@ -80,16 +220,22 @@ func (c *Context) handle(rsp http.ResponseWriter, req *Request, h Handler) {
     where = req.URL.Path
   }
   
+  // limit and decode the request entity
+  if err := c.service.prepareEntity(req); err != nil {
+    c.service.sendResponse(rsp, req, nil, err)
+    return
+  }
+  
   // determine if we need to trace the request
   trace := false
   if c.service.traceRequests != nil && len(c.service.traceRequests) > 0 {
     for _, e := range c.service.traceRequests {
       if e.MatchString(req.URL.Path) {
-        alt.Debugf("%s: [%s] (trace:%v) %s %s ", c.service.name, req.RemoteAddr, e, req.Method, where)
+        alt.Debugf("%s: [%v] (trace:%v) %s %s ", c.service.name, req.ClientIP(), e, req.Method, where)
         
         if req.Header != nil {
           for k, v := range req.Header {
-            if strings.EqualFold(k, "Authorization") {
+            if strings.EqualFold(k, "Authorization") || strings.EqualFold(k, "Proxy-Authorization") || strings.EqualFold(k, "Cookie") {
               alt.Debugf("  < %v: <%v suppressed>", k, len(v))
             }else{
               alt.Debugf("  < %v: %v", k, v)
@@ -100,7 +246,10 @@ func (c *Context) handle(rsp http.ResponseWriter, req *Request, h Handler) {
         if req.Body != nil {
           data, err := ioutil.ReadAll(req.Body)
           if err != nil {
-            c.service.sendResponse(rsp, req, nil, NewError(http.StatusInternalServerError, err))
+            if _, ok := err.(*Error); !ok {
+              err = NewError(http.StatusInternalServerError, err)
+            }
+            c.service.sendResponse(rsp, req, nil, err)
             return 
           }
           alt.Debugf("  <")
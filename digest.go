@@ -0,0 +1,317 @@
+package rest
+
+import (
+  "io"
+  "fmt"
+  "hash"
+  "strconv"
+  "strings"
+  "io/ioutil"
+  "net/http"
+  "crypto/sha256"
+  "crypto/sha512"
+  "encoding/base64"
+)
+
+/**
+ * Digest algorithms (RFC 9530)
+ */
+const (
+  DigestSHA256 = "sha-256"
+  DigestSHA512 = "sha-512"
+)
+
+/**
+ * Produce a hash for a digest algorithm; the result is nil if the
+ * algorithm is not supported
+ */
+func digestHash(alg string) hash.Hash {
+  switch strings.ToLower(alg) {
+    case DigestSHA256:
+      return sha256.New()
+    case DigestSHA512:
+      return sha512.New()
+    default:
+      return nil
+  }
+}
+
+/**
+ * Produce a digest field value, e.g., for Content-Digest, of data with
+ * each of the specified algorithms
+ */
+func FormatDigest(algs []string, data []byte) (string, error) {
+  d := make([]string, len(algs))
+  for i, e := range algs {
+    h := digestHash(e)
+    if h == nil {
+      return "", fmt.Errorf("Unsupported digest algorithm: %v", e)
+    }
+    h.Write(data)
+    d[i] = strings.ToLower(e) +"=:"+ base64.StdEncoding.EncodeToString(h.Sum(nil)) +":"
+  }
+  return strings.Join(d, ", "), nil
+}
+
+/**
+ * Parse a digest field value into digests by algorithm. Algorithms which
+ * are not supported are ignored.
+ */
+func ParseDigest(v string) (map[string][]byte, error) {
+  d := make(map[string][]byte)
+  for _, e := range strings.Split(v, ",") {
+    e = strings.TrimSpace(e)
+    if e == "" {
+      continue
+    }
+    x := strings.IndexByte(e, '=')
+    if x < 0 {
+      return nil, fmt.Errorf("Invalid digest: %v", e)
+    }
+    alg, val := strings.ToLower(strings.TrimSpace(e[:x])), strings.TrimSpace(e[x+1:])
+    if p := strings.IndexByte(val, ';'); p > 0 {
+      val = val[:p] // parameters are not meaningful for digests
+    }
+    if len(val) < 2 || val[0] != ':' || val[len(val)-1] != ':' {
+      return nil, fmt.Errorf("Invalid digest value for %v", alg)
+    }
+    if digestHash(alg) == nil {
+      continue
+    }
+    b, err := base64.StdEncoding.DecodeString(val[1:len(val)-1])
+    if err != nil {
+      return nil, fmt.Errorf("Invalid digest value for %v: %v", alg, err)
+    }
+    d[alg] = b
+  }
+  return d, nil
+}
+
+/**
+ * Verify data against a digest field value. Every supported digest the
+ * field provides must match and at least one must be provided.
+ */
+func VerifyDigest(v string, data []byte) error {
+  d, err := ParseDigest(v)
+  if err != nil {
+    return err
+  }
+  if len(d) == 0 {
+    return fmt.Errorf("No supported digest algorithm is provided; use %v or %v", DigestSHA256, DigestSHA512)
+  }
+  for alg, expect := range d {
+    h := digestHash(alg)
+    h.Write(data)
+    if string(h.Sum(nil)) != string(expect) {
+      return fmt.Errorf("Entity does not match %v digest", alg)
+    }
+  }
+  return nil
+}
+
+/**
+ * The preferences advertised when a request entity digest is rejected
+ */
+const wantDigest = "sha-256=10, sha-512=5"
+
+/**
+ * The most raw entity data read to finish computing the digest of a decoded
+ * request entity once its handler has consumed it
+ */
+const maxDigestDrain = 1 << 16
+
+/**
+ * Choose the most preferred supported algorithm from a Want-Content-Digest
+ * or Want-Repr-Digest field; the result is empty if none is acceptable
+ */
+func preferredDigest(v string) string {
+  var alg string
+  var best int64
+  for _, e := range strings.Split(v, ",") {
+    x := strings.IndexByte(e, '=')
+    if x < 0 {
+      continue
+    }
+    a := strings.ToLower(strings.TrimSpace(e[:x]))
+    w, err := strconv.ParseInt(strings.TrimSpace(e[x+1:]), 10, 64)
+    if err != nil || w < 1 || digestHash(a) == nil {
+      continue
+    }
+    if w > best {
+      alg, best = a, w
+    }
+  }
+  return alg
+}
+
+/**
+ * Determine the digests, by field, with which the entity of a response to a
+ * request is described. Content-Digest is produced with the algorithm the
+ * request prefers, if it has a Want-Content-Digest field, or otherwise with
+ * those the service is configured to produce; Repr-Digest is produced only
+ * when requested. Responses are not content-coded, so the two are the same.
+ */
+func (s *Service) responseDigests(req *Request) map[string][]string {
+  d := make(map[string][]string)
+  if w := req.Header.Get("Want-Content-Digest"); w != "" {
+    if a := preferredDigest(w); a != "" {
+      d["Content-Digest"] = []string{a}
+    }
+  }else if s != nil {
+    for _, e := range s.contentDigest {
+      if digestHash(e) != nil {
+        d["Content-Digest"] = append(d["Content-Digest"], e)
+      }
+    }
+  }
+  if w := req.Header.Get("Want-Repr-Digest"); w != "" {
+    if a := preferredDigest(w); a != "" {
+      d["Repr-Digest"] = []string{a}
+    }
+  }
+  if len(d) == 0 {
+    return nil
+  }
+  return d
+}
+
+/**
+ * Set digest fields describing data
+ */
+func setDigests(h http.Header, d map[string][]string, data []byte) error {
+  for k, v := range d {
+    f, err := FormatDigest(v, data)
+    if err != nil {
+      return err
+    }
+    h.Set(k, f)
+  }
+  return nil
+}
+
+/**
+ * The fields which describe the digest of a request entity
+ */
+var entityDigestFields = []string{"Content-Digest", "Repr-Digest"}
+
+/**
+ * The digests of a request entity as it was received, before its content
+ * coding was removed, which are computed as the entity is read. Both
+ * Content-Digest and Repr-Digest describe the entity with its content
+ * coding applied; they differ only for partial content, which requests do
+ * not carry.
+ */
+type entityDigest struct {
+  raw     io.Reader
+  expect  map[string]map[string][]byte // expected digests by field and algorithm
+  hashes  map[string]hash.Hash
+  err     error
+  done    bool
+}
+
+/**
+ * Create an entity digest for the digest fields of a request; the result
+ * is nil if the request has none
+ */
+func newEntityDigest(raw io.Reader, header http.Header) (*entityDigest, error) {
+  expect := make(map[string]map[string][]byte)
+  hashes := make(map[string]hash.Hash)
+  for _, f := range entityDigestFields {
+    v := header.Get(f)
+    if v == "" {
+      continue
+    }
+    d, err := ParseDigest(v)
+    if err != nil {
+      return nil, digestError(f, err)
+    }
+    expect[f] = d
+    for k, _ := range d {
+      if hashes[k] == nil {
+        hashes[k] = digestHash(k)
+      }
+    }
+  }
+  if len(expect) == 0 {
+    return nil, nil
+  }
+  return &entityDigest{raw:raw, expect:expect, hashes:hashes}, nil
+}
+
+/**
+ * Read raw entity data
+ */
+func (d *entityDigest) Read(p []byte) (int, error) {
+  n, err := d.raw.Read(p)
+  for _, h := range d.hashes {
+    h.Write(p[:n])
+  }
+  return n, err
+}
+
+/**
+ * Verify the digests, reading any raw data the decoder did not consume
+ */
+func (d *entityDigest) verify() error {
+  if d.done {
+    return d.err
+  }
+  d.done = true
+  n, err := io.CopyN(ioutil.Discard, d, maxDigestDrain + 1)
+  if err != nil && err != io.EOF {
+    d.err = NewErrorf(http.StatusBadRequest, "Could not read entity: %v", err)
+    return d.err
+  }else if n > maxDigestDrain {
+    d.err = NewErrorf(http.StatusBadRequest, "Entity has unexpected trailing data")
+    return d.err
+  }
+  sums := make(map[string]string)
+  for alg, h := range d.hashes {
+    sums[alg] = string(h.Sum(nil))
+  }
+  for _, f := range entityDigestFields {
+    expect, ok := d.expect[f]
+    if !ok {
+      continue
+    }
+    if len(expect) == 0 {
+      d.err = digestError(f, fmt.Errorf("No supported digest algorithm is provided; use %v or %v", DigestSHA256, DigestSHA512))
+      return d.err
+    }
+    for alg, e := range expect {
+      if sums[alg] != string(e) {
+        d.err = digestError(f, fmt.Errorf("Entity does not match %v digest", alg))
+        return d.err
+      }
+    }
+  }
+  return nil
+}
+
+/**
+ * Verify the request entity, as read by a handler, against the request's
+ * Content-Digest and Repr-Digest fields, if it has them. Both describe the
+ * entity as it was received; if the service removed its content coding,
+ * they are verified against the digests computed as the entity was
+ * decoded. The result is a 400 if a digest does not match.
+ */
+func (r *Request) VerifyEntityDigest(data []byte) error {
+  if r.digest != nil {
+    return r.digest.verify()
+  }
+  for _, f := range entityDigestFields {
+    if v := r.Header.Get(f); v != "" {
+      if err := VerifyDigest(v, data); err != nil {
+        return digestError(f, err)
+      }
+    }
+  }
+  return nil
+}
+
+/**
+ * Produce an error for an entity which does not match its digest
+ */
+func digestError(field string, err error) *Error {
+  return NewErrorf(http.StatusBadRequest, "Invalid %v: %v", field, err).SetHeaders(map[string]string{"Want-"+ field: wantDigest})
+}
@@ -4,6 +4,7 @@ import (
   "io"
   "fmt"
   "bytes"
+  "io/ioutil"
   "net/http"
   "encoding/json"
 )
@@ -44,24 +45,48 @@ func (e BytesEntity) ContentType() string {
 type EntityHandler func(http.ResponseWriter, *Request, int, interface{})(error)
 
 /**
- * The default entity handler
+ * The default entity handler. Entities are described by Content-Digest when
+ * the service is configured to produce digests or the request asks for them
+ * with Want-Content-Digest. Entities which are readers, other than bytes
+ * entities, are only digested when the service is configured to produce
+ * digests, since they must be buffered to do so.
  */
 func DefaultEntityHandler(rsp http.ResponseWriter, req *Request, status int, content interface{}) error {
+  digests := req.service.responseDigests(req)
   switch e := content.(type) {
     
     case nil:
       rsp.WriteHeader(status)
     
     case Entity:
+      var r io.Reader = e
+      if b, ok := e.(*BytesEntity); ok && digests != nil {
+        if err := setDigests(rsp.Header(), digests, b.Bytes()); err != nil {
+          return err
+        }
+      }else if digests != nil && req.service != nil && len(req.service.contentDigest) > 0 {
+        data, err := ioutil.ReadAll(e)
+        if err != nil {
+          return fmt.Errorf("Could not read entity: %v\nIn response to: %v %v", err, req.Method, req.URL)
+        }
+        if err := setDigests(rsp.Header(), digests, data); err != nil {
+          return err
+        }
+        r = bytes.NewReader(data)
+      }
+      
       rsp.Header().Add("Content-Type", e.ContentType())
       rsp.WriteHeader(status)
       
-      n, err := io.Copy(rsp, e)
+      n, err := io.Copy(rsp, r)
       if err != nil {
         return fmt.Errorf("Could not write entity: %v\nIn response to: %v %v\nEntity: %d bytes written", err, req.Method, req.URL, n)
       }
       
     case json.RawMessage:
+      if err := setDigests(rsp.Header(), digests, []byte(e)); err != nil {
+        return err
+      }
       rsp.Header().Add("Content-Type", "application/json")
       rsp.WriteHeader(status)
       
@@ -71,13 +96,16 @@ func DefaultEntityHandler(rsp http.ResponseWriter, req *Request, status int, con
       }
       
     default:
-      rsp.Header().Add("Content-Type", "application/json")
-      rsp.WriteHeader(status)
-      
       data, err := json.Marshal(content)
       if err != nil {
         return fmt.Errorf("Could not marshal entity: %v\nIn response to: %v %v", err, req.Method, req.URL)
       }
+      if err := setDigests(rsp.Header(), digests, data); err != nil {
+        return err
+      }
+      
+      rsp.Header().Add("Content-Type", "application/json")
+      rsp.WriteHeader(status)
       
       _, err = rsp.Write(data)
       if err != nil {
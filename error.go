@@ -70,3 +70,19 @@ type basicError struct {
 func (e basicError) Error() string {
   return e.Message
 }
+
+/**
+ * An error with detail
+ */
+type detailError struct {
+  Status    int         `json:"status"`
+  Message   string      `json:"message"`
+  Detail    interface{} `json:"detail"`
+}
+
+/**
+ * Also an error
+ */
+func (e detailError) Error() string {
+  return e.Message
+}
@@ -0,0 +1,421 @@
+package rest
+
+import (
+  "fmt"
+  "time"
+  "bytes"
+  "strings"
+  "net/http"
+  "crypto/sha256"
+)
+
+/**
+ * ETag generation modes
+ */
+type ETagMode int
+const (
+  ETagNone    ETagMode = iota // entity tags are only produced by entities that provide them
+  ETagStrong                  // strong entity tags are produced by hashing rendered entities
+  ETagWeak                    // weak entity tags are produced by hashing rendered entities
+)
+
+/**
+ * An entity tag
+ */
+type ETag struct {
+  Tag   string
+  Weak  bool
+}
+
+/**
+ * Create a strong entity tag
+ */
+func StrongETag(t string) ETag {
+  return ETag{t, false}
+}
+
+/**
+ * Create a weak entity tag
+ */
+func WeakETag(t string) ETag {
+  return ETag{t, true}
+}
+
+/**
+ * Compute an entity tag by hashing the provided data
+ */
+func HashETag(data []byte, weak bool) ETag {
+  sum := sha256.Sum256(data)
+  return ETag{fmt.Sprintf("%x", sum[:16]), weak}
+}
+
+/**
+ * Is this tag the zero value
+ */
+func (e ETag) IsZero() bool {
+  return e.Tag == ""
+}
+
+/**
+ * Format the tag as it appears in a header
+ */
+func (e ETag) String() string {
+  if e.Weak {
+    return `W/"`+ e.Tag +`"`
+  }else{
+    return `"`+ e.Tag +`"`
+  }
+}
+
+/**
+ * Compare tags. A strong comparison requires that both tags are strong.
+ */
+func (e ETag) Matches(o ETag, weak bool) bool {
+  if !weak && (e.Weak || o.Weak) {
+    return false
+  }
+  return e.Tag == o.Tag
+}
+
+/**
+ * Parse a single entity tag
+ */
+func ParseETag(s string) (ETag, error) {
+  var e ETag
+  s = strings.TrimSpace(s)
+  if strings.HasPrefix(s, "W/") {
+    e.Weak = true
+    s = s[2:]
+  }
+  if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+    return ETag{}, fmt.Errorf("Invalid entity tag: %v", s)
+  }
+  e.Tag = s[1:len(s)-1]
+  return e, nil
+}
+
+/**
+ * Parse a list of entity tags as found in If-Match or If-None-Match. The
+ * wildcard is reported separately. Malformed tags are ignored.
+ */
+func parseETagList(h string) ([]ETag, bool) {
+  var tags []ETag
+  for _, e := range strings.Split(h, ",") {
+    e = strings.TrimSpace(e)
+    if e == "*" {
+      return nil, true
+    }
+    if t, err := ParseETag(e); err == nil {
+      tags = append(tags, t)
+    }
+  }
+  return tags, false
+}
+
+/**
+ * Determine if a tag matches any in a precondition list. The wildcard
+ * matches any current representation, whether or not it has a tag.
+ */
+func matchETagList(h string, tag ETag, exists, weak bool) bool {
+  list, any := parseETagList(h)
+  if any {
+    return exists
+  }
+  if tag.IsZero() {
+    return false
+  }
+  for _, e := range list {
+    if e.Matches(tag, weak) {
+      return true
+    }
+  }
+  return false
+}
+
+/**
+ * Implemented by entities that can describe their own entity tag, which
+ * avoids hashing the rendered entity
+ */
+type ETagger interface {
+  ETag()(ETag)
+}
+
+/**
+ * Implemented by entities that know when they were last modified
+ */
+type LastModifier interface {
+  LastModified()(time.Time)
+}
+
+/**
+ * Evaluate conditional request headers against the current state of a
+ * resource as described by RFC 7232, section 6. A zero tag or modification
+ * time means the validator is not available; if both are zero the resource
+ * is taken not to exist. The result is zero if the request should proceed,
+ * otherwise the status to respond with.
+ */
+func evaluatePreconditions(req *Request, tag ETag, modified time.Time) int {
+  exists := !tag.IsZero() || !modified.IsZero()
+
+  if h := req.Header.Get("If-Match"); h != "" {
+    if !matchETagList(h, tag, exists, false) {
+      return http.StatusPreconditionFailed
+    }
+  }else if h := req.Header.Get("If-Unmodified-Since"); h != "" && !modified.IsZero() {
+    if t, err := http.ParseTime(h); err == nil && modified.Truncate(time.Second).After(t) {
+      return http.StatusPreconditionFailed
+    }
+  }
+
+  if st := evaluateCacheConditions(req, tag, modified); st != 0 {
+    return st
+  }
+  if h := req.Header.Get("If-None-Match"); h != "" && !isSafeMethod(req.Method) {
+    if matchETagList(h, tag, exists, true) {
+      return http.StatusPreconditionFailed
+    }
+  }
+
+  return 0
+}
+
+/**
+ * Evaluate the conditional request headers which describe a cache's copy
+ * of a representation, If-None-Match and If-Modified-Since, for GET and
+ * HEAD requests. The result is zero if the request should proceed or 304 if
+ * the representation has not changed.
+ *
+ * This is all that can be evaluated once a response has been produced:
+ * preconditions on the state of the resource, which guard unsafe methods,
+ * must be checked against it before it is modified, which routes with an
+ * ATTR_VALIDATORS attribute do automatically and handlers can do with
+ * Request.CheckPreconditions.
+ */
+func evaluateCacheConditions(req *Request, tag ETag, modified time.Time) int {
+  if !isSafeMethod(req.Method) {
+    return 0
+  }
+  if h := req.Header.Get("If-None-Match"); h != "" {
+    if matchETagList(h, tag, !tag.IsZero() || !modified.IsZero(), true) {
+      return http.StatusNotModified
+    }
+  }else if h := req.Header.Get("If-Modified-Since"); h != "" && !modified.IsZero() {
+    if t, err := http.ParseTime(h); err == nil && !modified.Truncate(time.Second).After(t) {
+      return http.StatusNotModified
+    }
+  }
+  return 0
+}
+
+/**
+ * Determine if a method is one for which a cached representation can be
+ * revalidated
+ */
+func isSafeMethod(m string) bool {
+  return m == "GET" || m == "HEAD"
+}
+
+/**
+ * Check the request's conditional headers against the current state of the
+ * resource it targets, before the handler acts on it. Handlers for unsafe
+ * methods must check preconditions before they modify anything, since
+ * If-Match and If-Unmodified-Since are not evaluated against the response;
+ * this is done for them by routes with an ATTR_VALIDATORS attribute, or
+ * handlers can do it themselves with this method. Pass a zero tag and time
+ * if the resource does not exist. The error returned, if any, should be
+ * returned from the handler.
+ */
+func (r *Request) CheckPreconditions(tag ETag, modified time.Time) error {
+  switch s := evaluatePreconditions(r, tag, modified); s {
+    case 0:
+      return nil
+    case http.StatusNotModified:
+      return NewError(s, nil).SetHeaders(validatorHeaders(tag, modified))
+    default:
+      return NewErrorf(s, "Precondition failed for %v %v", r.Method, r.URL.Path)
+  }
+}
+
+/**
+ * Route attributes understood by the precondition check
+ */
+const (
+  ATTR_VALIDATORS = "rest.validators" // a Validators loader; unsafe requests to the route are checked against the validators it produces before the handler runs
+)
+
+/**
+ * Load the current validators of the resource a request targets. A zero
+ * tag and time mean the resource does not exist.
+ */
+type Validators func(*Request)(ETag, time.Time, error)
+
+/**
+ * Produce a handler which checks the conditional headers of requests with
+ * unsafe methods against the current validators of the resource they
+ * target, responding 412 Precondition Failed instead of continuing if they
+ * are not satisfied, so handlers need not call CheckPreconditions
+ * themselves. Requests without conditional headers proceed without loading
+ * validators.
+ *
+ * Routes created with Context.HandleFunc or Context.Route which have an
+ * ATTR_VALIDATORS attribute are checked this way automatically, after the
+ * context middleware and before the route's handler.
+ */
+func Preconditions(v Validators) Handler {
+  return preconditionHandler{v}
+}
+
+/**
+ * Checks preconditions before a request proceeds
+ */
+type preconditionHandler struct {
+  validators Validators
+}
+
+/**
+ * Serve a request
+ */
+func (h preconditionHandler) ServeRequest(rsp http.ResponseWriter, req *Request, pln Pipeline) (interface{}, error) {
+  if isSafeMethod(req.Method) || req.Method == "OPTIONS" || !hasPreconditions(req) {
+    return pln.Next(rsp, req)
+  }
+  tag, modified, err := h.validators(req)
+  if err != nil {
+    return nil, err
+  }
+  if err := req.CheckPreconditions(tag, modified); err != nil {
+    return nil, err
+  }
+  return pln.Next(rsp, req)
+}
+
+/**
+ * Determine if a request has conditional headers
+ */
+func hasPreconditions(req *Request) bool {
+  for _, e := range []string{"If-Match", "If-None-Match", "If-Unmodified-Since"} {
+    if req.Header.Get(e) != "" {
+      return true
+    }
+  }
+  return false
+}
+
+/**
+ * Produce validator headers
+ */
+func validatorHeaders(tag ETag, modified time.Time) map[string]string {
+  h := make(map[string]string)
+  if !tag.IsZero() {
+    h["ETag"] = tag.String()
+  }
+  if !modified.IsZero() {
+    h["Last-Modified"] = modified.UTC().Format(http.TimeFormat)
+  }
+  return h
+}
+
+/**
+ * Send an entity, producing validators for it and, for GET and HEAD
+ * requests, responding with 304 if the client's copy is current. If the
+ * entity does not describe its own tag and the service produces tags, the
+ * entity is rendered to a buffer and hashed.
+ */
+func (s *Service) sendConditionalEntity(rsp http.ResponseWriter, req *Request, status int, content interface{}) error {
+  var tag ETag
+  var modified time.Time
+
+  if e, ok := content.(ETagger); ok {
+    tag = e.ETag()
+  }
+  if e, ok := content.(LastModifier); ok {
+    modified = e.LastModified()
+  }
+
+  if !tag.IsZero() || s.etagMode == ETagNone {
+    if evaluateCacheConditions(req, tag, modified) == http.StatusNotModified {
+      return writeNotModified(rsp, validatorHeaders(tag, modified))
+    }
+    for k, v := range validatorHeaders(tag, modified) {
+      rsp.Header().Set(k, v)
+    }
+    return s.writeEntity(rsp, req, status, content)
+  }
+
+  buf := &entityBuffer{header:make(http.Header)}
+  err := s.writeEntity(buf, req, status, content)
+  if err != nil {
+    return err
+  }
+
+  if h := buf.header.Get("ETag"); h != "" {
+    tag, _ = ParseETag(h) // the entity handler provided its own tag
+  }else if buf.status == http.StatusOK {
+    tag = HashETag(buf.Bytes(), s.etagMode == ETagWeak)
+  }
+
+  if buf.status == http.StatusOK && evaluateCacheConditions(req, tag, modified) == http.StatusNotModified {
+    return writeNotModified(rsp, validatorHeaders(tag, modified))
+  }
+
+  for k, v := range buf.header {
+    rsp.Header()[k] = v
+  }
+  for k, v := range validatorHeaders(tag, modified) {
+    rsp.Header().Set(k, v)
+  }
+
+  rsp.WriteHeader(buf.status)
+  _, err = rsp.Write(buf.Bytes())
+  if err != nil {
+    return fmt.Errorf("Could not write entity: %v\nIn response to: %v %v\nEntity: %d bytes", err, req.Method, req.URL, buf.Len())
+  }
+
+  return nil
+}
+
+/**
+ * Respond with 304 Not Modified
+ */
+func writeNotModified(rsp http.ResponseWriter, headers map[string]string) error {
+  for k, v := range headers {
+    rsp.Header().Set(k, v)
+  }
+  rsp.WriteHeader(http.StatusNotModified)
+  return nil
+}
+
+/**
+ * A response writer that buffers a rendered entity
+ */
+type entityBuffer struct {
+  bytes.Buffer
+  header  http.Header
+  status  int
+}
+
+/**
+ * Obtain headers
+ */
+func (b *entityBuffer) Header() http.Header {
+  return b.header
+}
+
+/**
+ * Write the status
+ */
+func (b *entityBuffer) WriteHeader(s int) {
+  if b.status == 0 {
+    b.status = s
+  }
+}
+
+/**
+ * Write data
+ */
+func (b *entityBuffer) Write(p []byte) (int, error) {
+  if b.status == 0 {
+    b.status = http.StatusOK
+  }
+  return b.Buffer.Write(p)
+}
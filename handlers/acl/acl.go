@@ -0,0 +1,322 @@
+package acl
+
+import (
+  "os"
+  "io"
+  "fmt"
+  "net"
+  "sync"
+  "time"
+  "bufio"
+  "strings"
+  "net/http"
+)
+
+import (
+  "github.com/bww/go-rest"
+  "github.com/bww/go-alert"
+)
+
+/**
+ * Route attributes understood by the ACL. Values may be a network or
+ * network name as a string, a []string of them, or a []*net.IPNet.
+ */
+const (
+  ATTR_ALLOW  = "acl.allow" // networks from which the route may be accessed
+  ATTR_DENY   = "acl.deny"  // networks from which the route may not be accessed
+)
+
+/**
+ * Access rules
+ */
+type Rules struct {
+  Allow     []*net.IPNet            // if any are provided, only clients in these networks are allowed
+  Deny      []*net.IPNet            // clients in these networks are denied, even if they are allowed
+  Networks  map[string][]*net.IPNet // named networks, which route attributes may refer to
+}
+
+/**
+ * Parse rules. Each line is a directive; blank lines and those beginning
+ * with # are ignored:
+ *
+ *   allow <network|name> ...
+ *   deny <network|name> ...
+ *   network <name> <network> ...
+ *
+ * Networks are in CIDR notation or are bare addresses. Names refer to
+ * networks defined anywhere in the rules.
+ */
+func ParseRules(r io.Reader) (*Rules, error) {
+  rules := &Rules{Networks:make(map[string][]*net.IPNet)}
+  var allow, deny []string
+
+  scanner := bufio.NewScanner(r)
+  for n := 1; scanner.Scan(); n++ {
+    l := strings.TrimSpace(scanner.Text())
+    if l == "" || l[0] == '#' {
+      continue
+    }
+    f := strings.Fields(l)
+    if len(f) < 2 {
+      return nil, fmt.Errorf("Line %d: Directive has no networks: %v", n, l)
+    }
+    switch strings.ToLower(f[0]) {
+      case "allow":
+        allow = append(allow, f[1:]...)
+      case "deny":
+        deny = append(deny, f[1:]...)
+      case "network":
+        if len(f) < 3 {
+          return nil, fmt.Errorf("Line %d: Network has no members: %v", n, f[1])
+        }
+        x, err := rest.ParseNetworks(f[2:]...)
+        if err != nil {
+          return nil, fmt.Errorf("Line %d: %v", n, err)
+        }
+        rules.Networks[f[1]] = append(rules.Networks[f[1]], x...)
+      default:
+        return nil, fmt.Errorf("Line %d: Unsupported directive: %v", n, f[0])
+    }
+  }
+  if err := scanner.Err(); err != nil {
+    return nil, err
+  }
+
+  var err error
+  rules.Allow, err = rules.resolve(allow)
+  if err != nil {
+    return nil, err
+  }
+  rules.Deny, err = rules.resolve(deny)
+  if err != nil {
+    return nil, err
+  }
+  return rules, nil
+}
+
+/**
+ * Resolve networks and network names
+ */
+func (r *Rules) resolve(s []string) ([]*net.IPNet, error) {
+  var n []*net.IPNet
+  for _, e := range s {
+    if x, ok := r.Networks[e]; ok {
+      n = append(n, x...)
+      continue
+    }
+    x, err := rest.ParseNetworks(e)
+    if err != nil {
+      return nil, err
+    }
+    n = append(n, x...)
+  }
+  return n, nil
+}
+
+/**
+ * Resolve an attribute value
+ */
+func (r *Rules) resolveAttr(v interface{}) ([]*net.IPNet, error) {
+  switch c := v.(type) {
+    case nil:
+      return nil, nil
+    case []*net.IPNet:
+      return c, nil
+    case string:
+      return r.resolve([]string{c})
+    case []string:
+      return r.resolve(c)
+    default:
+      return nil, fmt.Errorf("Unsupported network attribute type: %T", v)
+  }
+}
+
+/**
+ * An access control handler, which allows or denies requests by the
+ * address of the client. The client address is that resolved through the
+ * service's trusted proxies.
+ *
+ * Requests are checked against the global rules and then the rules of the
+ * route; a request must be allowed by both and denied by neither. Denied
+ * requests produce a 403. The handler should be used in a context pipeline
+ * so that route attributes are available to it.
+ */
+type ACL struct {
+  sync.RWMutex
+  path    string
+  mod     time.Time
+  rules   *Rules
+}
+
+/**
+ * Create an ACL with rules
+ */
+func New(rules *Rules) *ACL {
+  if rules == nil {
+    rules = &Rules{}
+  }
+  return &ACL{rules:rules}
+}
+
+/**
+ * Create an ACL with rules read from a file, which may be reloaded
+ */
+func Load(path string) (*ACL, error) {
+  a := &ACL{path:path}
+  if err := a.Reload(); err != nil {
+    return nil, err
+  }
+  return a, nil
+}
+
+/**
+ * Obtain the current rules
+ */
+func (a *ACL) Rules() *Rules {
+  a.RLock()
+  defer a.RUnlock()
+  return a.rules
+}
+
+/**
+ * Replace the rules
+ */
+func (a *ACL) SetRules(r *Rules) {
+  if r == nil {
+    r = &Rules{}
+  }
+  a.Lock()
+  a.rules = r
+  a.Unlock()
+}
+
+/**
+ * Reload rules from the file the ACL was loaded from. If the rules cannot
+ * be read the current rules remain in effect.
+ */
+func (a *ACL) Reload() error {
+  if a.path == "" {
+    return fmt.Errorf("ACL was not loaded from a file")
+  }
+  file, err := os.Open(a.path)
+  if err != nil {
+    return err
+  }
+  defer file.Close()
+  info, err := file.Stat()
+  if err != nil {
+    return err
+  }
+  rules, err := ParseRules(file)
+  if err != nil {
+    return fmt.Errorf("%v: %v", a.path, err)
+  }
+  a.Lock()
+  a.rules, a.mod = rules, info.ModTime()
+  a.Unlock()
+  return nil
+}
+
+/**
+ * Reload rules whenever the file they were loaded from changes, checking
+ * at the specified interval until the returned function is called. An
+ * error is returned if the ACL was not loaded from a file or the interval
+ * is not positive.
+ */
+func (a *ACL) Watch(interval time.Duration) (func(), error) {
+  if a.path == "" {
+    return nil, fmt.Errorf("ACL was not loaded from a file")
+  }
+  if interval <= 0 {
+    return nil, fmt.Errorf("Invalid watch interval: %v", interval)
+  }
+  stop := make(chan struct{})
+  go func() {
+    t := time.NewTicker(interval)
+    defer t.Stop()
+    for {
+      select {
+        case <-stop:
+          return
+        case <-t.C:
+          info, err := os.Stat(a.path)
+          if err != nil {
+            alt.Errorf("acl: Could not check rules: %v", err)
+            continue
+          }
+          a.RLock()
+          changed := !info.ModTime().Equal(a.mod)
+          a.RUnlock()
+          if changed {
+            if err := a.Reload(); err != nil {
+              alt.Errorf("acl: Could not reload rules: %v", err)
+            }else{
+              alt.Debugf("acl: Reloaded rules from %v", a.path)
+            }
+          }
+      }
+    }
+  }()
+  var once sync.Once
+  return func() { once.Do(func(){ close(stop) }) }, nil
+}
+
+/**
+ * Determine if a client is allowed access to a route with the provided
+ * attributes
+ */
+func (a *ACL) Allowed(ip net.IP, attrs rest.Attrs) (bool, error) {
+  if ip == nil {
+    return false, nil
+  }
+  rules := a.Rules()
+  if !permits(rules.Allow, rules.Deny, ip) {
+    return false, nil
+  }
+  allow, err := rules.resolveAttr(attrs[ATTR_ALLOW])
+  if err != nil {
+    return false, err
+  }
+  deny, err := rules.resolveAttr(attrs[ATTR_DENY])
+  if err != nil {
+    return false, err
+  }
+  return permits(allow, deny, ip), nil
+}
+
+/**
+ * Determine if an address is permitted by allow and deny lists
+ */
+func permits(allow, deny []*net.IPNet, ip net.IP) bool {
+  if contains(deny, ip) {
+    return false
+  }
+  return len(allow) == 0 || contains(allow, ip)
+}
+
+/**
+ * Determine if an address is in any of a set of networks
+ */
+func contains(n []*net.IPNet, ip net.IP) bool {
+  for _, e := range n {
+    if e.Contains(ip) {
+      return true
+    }
+  }
+  return false
+}
+
+/**
+ * Serve a request
+ */
+func (a *ACL) ServeRequest(rsp http.ResponseWriter, req *rest.Request, pln rest.Pipeline) (interface{}, error) {
+  ip := req.ClientIP()
+  ok, err := a.Allowed(ip, req.Attrs)
+  if err != nil {
+    return nil, rest.NewErrorf(http.StatusInternalServerError, "Could not evaluate access rules: %v", err)
+  }
+  if !ok {
+    return nil, rest.NewErrorf(http.StatusForbidden, "Access denied for client: %v", ip)
+  }
+  return pln.Next(rsp, req)
+}
@@ -0,0 +1,217 @@
+package apikey
+
+import (
+  "time"
+  "net/http"
+  "encoding/json"
+)
+
+import (
+  "github.com/bww/go-rest"
+  "github.com/bww/go-rest/httputil"
+  "github.com/gorilla/mux"
+)
+
+/**
+ * An issued key
+ */
+type issued struct {
+  Token string  `json:"token"` // the key itself, which cannot be obtained again
+  Key   *Key    `json:"key"`
+}
+
+/**
+ * A request to issue a key
+ */
+type issueRequest struct {
+  Spec
+  TTL string `json:"ttl"` // the lifetime of the key as a duration, e.g., "720h", as an alternative to an expiry time
+}
+
+/**
+ * A request to rotate a key
+ */
+type rotateRequest struct {
+  Grace string `json:"grace"` // the period, as a duration, for which the original key remains valid
+}
+
+/**
+ * Register routes which administer keys in a context. The routes are:
+ *
+ *   GET     /keys             list keys
+ *   POST    /keys             issue a key, described by a Spec, with an optional "ttl"
+ *   GET     /keys/{id}        obtain a key
+ *   POST    /keys/{id}/rotate rotate a key, with an optional "grace" period
+ *   DELETE  /keys/{id}        revoke a key
+ *
+ * Key hashes are never disclosed. Requests must be authenticated. A caller
+ * may only issue and rotate keys for their own subject, unless they have
+ * the AdminRole, and may only grant keys roles and scopes which they hold
+ * themselves or which are listed in GrantRoles and GrantScopes. The routes
+ * also allow callers to list and revoke any key, so the context should
+ * require authorization, or the attributes provided, which are applied to
+ * every route, should.
+ */
+func (k *Keys) Admin(c *rest.Context, a ...rest.Attrs) {
+  c.HandleFunc("/keys", k.list, a...).Methods("GET")
+  c.HandleFunc("/keys", k.issue, a...).Methods("POST")
+  c.HandleFunc("/keys/{id}", k.get, a...).Methods("GET")
+  c.HandleFunc("/keys/{id}/rotate", k.rotate, a...).Methods("POST")
+  c.HandleFunc("/keys/{id}", k.revoke, a...).Methods("DELETE")
+}
+
+/**
+ * List keys
+ */
+func (k *Keys) list(rsp http.ResponseWriter, req *rest.Request, pln rest.Pipeline) (interface{}, error) {
+  keys, err := k.store.List()
+  if err != nil {
+    return nil, err
+  }
+  for i, e := range keys {
+    keys[i] = e.Public()
+  }
+  return keys, nil
+}
+
+/**
+ * Issue a key
+ */
+func (k *Keys) issue(rsp http.ResponseWriter, req *rest.Request, pln rest.Pipeline) (interface{}, error) {
+  var spec issueRequest
+  if err := httputil.UnmarshalRequestEntity(req, &spec); err != nil {
+    return nil, err
+  }
+  if spec.TTL != "" {
+    if spec.Expires != nil {
+      return nil, rest.NewErrorf(http.StatusBadRequest, "A key may have an expiry time or a TTL, but not both")
+    }
+    d, err := time.ParseDuration(spec.TTL)
+    if err != nil || d <= 0 {
+      return nil, rest.NewErrorf(http.StatusBadRequest, "Invalid TTL: %q", spec.TTL)
+    }
+    t := time.Now().UTC().Add(d)
+    spec.Expires = &t
+  }
+  if spec.Subject == "" && req.Principal != nil {
+    spec.Subject = req.Principal.Subject
+  }
+  if err := k.authorizeSpec(req.Principal, spec.Spec); err != nil {
+    return nil, err
+  }
+  token, key, err := k.Issue(spec.Spec)
+  if err != nil {
+    return nil, err
+  }
+  return issued{token, key.Public()}, nil
+}
+
+/**
+ * Determine if a principal may manage keys for a subject
+ */
+func (k *Keys) authorizeSubject(p *rest.Principal, subject string) error {
+  if p == nil {
+    return rest.NewErrorf(http.StatusUnauthorized, "Authentication required")
+  }
+  if subject != p.Subject && (k.opts.AdminRole == "" || !p.HasRole(k.opts.AdminRole)) {
+    return rest.NewErrorf(http.StatusForbidden, "Not permitted to manage keys for subject: %v", subject)
+  }
+  return nil
+}
+
+/**
+ * Determine if a principal may issue a key as specified
+ */
+func (k *Keys) authorizeSpec(p *rest.Principal, spec Spec) error {
+  if err := k.authorizeSubject(p, spec.Subject); err != nil {
+    return err
+  }
+  for _, e := range spec.Roles {
+    if !p.HasRole(e) && !contains(k.opts.GrantRoles, e) {
+      return rest.NewErrorf(http.StatusForbidden, "Not permitted to grant role: %v", e)
+    }
+  }
+  for _, e := range spec.Scopes {
+    if !p.HasScope(e) && !contains(k.opts.GrantScopes, e) {
+      return rest.NewErrorf(http.StatusForbidden, "Not permitted to grant scope: %v", e)
+    }
+  }
+  return nil
+}
+
+/**
+ * Determine if a set contains a value
+ */
+func contains(l []string, v string) bool {
+  for _, e := range l {
+    if e == v {
+      return true
+    }
+  }
+  return false
+}
+
+/**
+ * Obtain a key
+ */
+func (k *Keys) get(rsp http.ResponseWriter, req *rest.Request, pln rest.Pipeline) (interface{}, error) {
+  id := mux.Vars(req.Request)["id"]
+  key, err := k.store.Get(id)
+  if err != nil {
+    return nil, err
+  }
+  if key == nil {
+    return nil, rest.NewErrorf(http.StatusNotFound, "No such key: %v", id)
+  }
+  return key.Public(), nil
+}
+
+/**
+ * Rotate a key
+ */
+func (k *Keys) rotate(rsp http.ResponseWriter, req *rest.Request, pln rest.Pipeline) (interface{}, error) {
+  data, err := httputil.RequestEntity(req)
+  if err != nil {
+    return nil, err
+  }
+  var grace time.Duration
+  if len(data) > 0 {
+    var r rotateRequest
+    if err := json.Unmarshal(data, &r); err != nil {
+      return nil, rest.NewErrorf(http.StatusBadRequest, "Could not unmarshal request entity: %v", err)
+    }
+    if r.Grace != "" {
+      grace, err = time.ParseDuration(r.Grace)
+      if err != nil || grace < 0 {
+        return nil, rest.NewErrorf(http.StatusBadRequest, "Invalid grace period: %q", r.Grace)
+      }
+    }
+  }
+  id := mux.Vars(req.Request)["id"]
+  key, err := k.store.Get(id)
+  if err != nil {
+    return nil, err
+  }
+  if key == nil {
+    return nil, rest.NewErrorf(http.StatusNotFound, "No such key: %v", id)
+  }
+  if err := k.authorizeSpec(req.Principal, Spec{Subject:key.Subject, Roles:key.Roles, Scopes:key.Scopes}); err != nil {
+    return nil, err // the replacement is a new key with the same grants
+  }
+  token, key, err := k.Rotate(id, grace)
+  if err != nil {
+    return nil, err
+  }
+  return issued{token, key.Public()}, nil
+}
+
+/**
+ * Revoke a key
+ */
+func (k *Keys) revoke(rsp http.ResponseWriter, req *rest.Request, pln rest.Pipeline) (interface{}, error) {
+  key, err := k.Revoke(mux.Vars(req.Request)["id"])
+  if err != nil {
+    return nil, err
+  }
+  return key.Public(), nil
+}
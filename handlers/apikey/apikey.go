@@ -0,0 +1,324 @@
+package apikey
+
+import (
+  "fmt"
+  "sync"
+  "time"
+  "strings"
+  "net/http"
+  "crypto/rand"
+  "crypto/sha256"
+  "crypto/subtle"
+  "encoding/hex"
+)
+
+import (
+  "github.com/bww/go-rest"
+  "github.com/bww/go-rest/handlers/auth"
+)
+
+/**
+ * Claims set on the principals of requests authenticated with API keys
+ */
+const (
+  ClaimKeyId  = "key_id"  // the identifier of the key
+  ClaimTier   = "tier"    // the rate-limit tier of the key
+)
+
+/**
+ * Key defaults
+ */
+const (
+  DefaultPrefix = "key"
+  DefaultHeader = "X-API-Key"
+)
+
+var (
+  ErrExpired  = auth.InvalidCredentials("API key has expired")
+  ErrRevoked  = auth.InvalidCredentials("API key has been revoked")
+)
+
+/**
+ * Key options
+ */
+type Options struct {
+  Prefix      string    // the prefix of issued keys, which identifies what they are; defaults to DefaultPrefix
+  Header      string    // the header which provides keys; defaults to DefaultHeader
+  Query       string    // the query parameter which provides keys, if any
+  Tiers       []string  // if any are provided, keys must be in one of these rate-limit tiers
+  DefaultTier string    // the tier of keys which do not specify one
+  AdminRole   string    // a role which permits the admin routes to manage keys for any subject; without it, callers may only manage keys for their own subject
+  GrantRoles  []string  // roles which the admin routes may grant to keys in addition to those the caller holds
+  GrantScopes []string  // scopes which the admin routes may grant to keys in addition to those the caller holds
+}
+
+/**
+ * The specification of a key to issue
+ */
+type Spec struct {
+  Name    string      `json:"name"`
+  Subject string      `json:"subject"`
+  Roles   []string    `json:"roles"`
+  Scopes  []string    `json:"scopes"`
+  Tier    string      `json:"tier"`
+  Expires *time.Time  `json:"expires"`
+}
+
+/**
+ * API key management. Keys have the form <prefix>_<id>_<secret>; the
+ * identifier locates the key in the store and the key as a whole is
+ * compared against the stored hash.
+ *
+ * Requests are authenticated by the authenticator the manager provides,
+ * which should be used with the authentication handler. The principal of
+ * an authenticated request has the subject, roles and scopes of its key,
+ * and the key identifier and tier as claims.
+ *
+ * Changes to keys made through the manager are serialized, so a key cannot
+ * be rotated twice concurrently. Managers in separate processes which share
+ * a store are not coordinated.
+ */
+type Keys struct {
+  sync.Mutex
+  store KeyStore
+  opts  Options
+}
+
+/**
+ * Create a key manager
+ */
+func New(store KeyStore, opts Options) *Keys {
+  if opts.Prefix == "" {
+    opts.Prefix = DefaultPrefix
+  }
+  if opts.Header == "" {
+    opts.Header = DefaultHeader
+  }
+  return &Keys{store:store, opts:opts}
+}
+
+/**
+ * Obtain the store
+ */
+func (k *Keys) Store() KeyStore {
+  return k.store
+}
+
+/**
+ * Produce an authenticator for keys
+ */
+func (k *Keys) Authenticator() auth.Authenticator {
+  return auth.APIKey{Header:k.opts.Header, Query:k.opts.Query, Verify:k.Verify}
+}
+
+/**
+ * Issue a key, producing the key itself, which is not stored and cannot
+ * be obtained again, and its record
+ */
+func (k *Keys) Issue(spec Spec) (string, *Key, error) {
+  if spec.Subject == "" {
+    return "", nil, rest.NewErrorf(http.StatusBadRequest, "Key must have a subject")
+  }
+  tier, err := k.tier(spec.Tier)
+  if err != nil {
+    return "", nil, err
+  }
+
+  id, err := randomHex(8)
+  if err != nil {
+    return "", nil, err
+  }
+  secret, err := randomHex(24)
+  if err != nil {
+    return "", nil, err
+  }
+
+  prefix := k.opts.Prefix +"_"+ id
+  token := prefix +"_"+ secret
+  key := &Key{
+    Id: id,
+    Prefix: prefix,
+    Hash: hashKey(token),
+    Name: spec.Name,
+    Subject: spec.Subject,
+    Roles: spec.Roles,
+    Scopes: spec.Scopes,
+    Tier: tier,
+    Created: time.Now().UTC(),
+    Expires: spec.Expires,
+  }
+  if err := k.store.Put(key); err != nil {
+    return "", nil, err
+  }
+  return token, key, nil
+}
+
+/**
+ * Rotate a key by issuing a replacement with the same properties. The
+ * original key remains valid for the grace period, if any, so clients can
+ * switch to the replacement without interruption.
+ */
+func (k *Keys) Rotate(id string, grace time.Duration) (string, *Key, error) {
+  k.Lock()
+  defer k.Unlock()
+  key, err := k.store.Get(id)
+  if err != nil {
+    return "", nil, err
+  }
+  if key == nil {
+    return "", nil, rest.NewErrorf(http.StatusNotFound, "No such key: %v", id)
+  }
+  if key.Revoked != nil {
+    return "", nil, rest.NewErrorf(http.StatusConflict, "Key has been revoked or rotated: %v", id)
+  }
+
+  token, rep, err := k.Issue(Spec{Name:key.Name, Subject:key.Subject, Roles:key.Roles, Scopes:key.Scopes, Tier:key.Tier, Expires:key.Expires})
+  if err != nil {
+    return "", nil, err
+  }
+
+  at := time.Now().UTC().Add(grace)
+  key.Revoked = &at
+  if err := k.store.Put(key); err != nil {
+    return "", nil, err
+  }
+  return token, rep, nil
+}
+
+/**
+ * Revoke a key. Revoked keys remain in the store so that they can be
+ * audited.
+ */
+func (k *Keys) Revoke(id string) (*Key, error) {
+  k.Lock()
+  defer k.Unlock()
+  key, err := k.store.Get(id)
+  if err != nil {
+    return nil, err
+  }
+  if key == nil {
+    return nil, rest.NewErrorf(http.StatusNotFound, "No such key: %v", id)
+  }
+  now := time.Now().UTC()
+  if key.Revoked == nil || key.Revoked.After(now) {
+    key.Revoked = &now
+    if err := k.store.Put(key); err != nil {
+      return nil, err
+    }
+  }
+  return key, nil
+}
+
+/**
+ * Verify a key, producing the principal it identifies. This function is
+ * an auth.TokenVerifier.
+ */
+func (k *Keys) Verify(req *rest.Request, token string) (*rest.Principal, error) {
+  key, err := k.Lookup(token)
+  if err != nil {
+    return nil, err
+  }
+  return &rest.Principal{
+    Subject: key.Subject,
+    Scheme: "apikey",
+    Roles: key.Roles,
+    Scopes: key.Scopes,
+    Claims: map[string]interface{}{ClaimKeyId: key.Id, ClaimTier: key.Tier},
+    Expires: expires(key),
+  }, nil
+}
+
+/**
+ * Obtain the record of a key, if the key is valid
+ */
+func (k *Keys) Lookup(token string) (*Key, error) {
+  i := strings.LastIndex(token, "_")
+  if i < 0 {
+    return nil, auth.ErrInvalidCredentials
+  }
+  prefix := token[:i]
+  j := strings.LastIndex(prefix, "_")
+  if j < 0 || prefix[:j] != k.opts.Prefix {
+    return nil, auth.ErrInvalidCredentials
+  }
+
+  key, err := k.store.Get(prefix[j+1:])
+  if err != nil {
+    return nil, rest.NewErrorf(http.StatusInternalServerError, "Could not obtain key: %v", err)
+  }
+  if key == nil || subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashKey(token))) != 1 {
+    return nil, auth.ErrInvalidCredentials
+  }
+
+  now := time.Now()
+  if key.Revoked != nil && !now.Before(*key.Revoked) {
+    return nil, ErrRevoked
+  }
+  if key.Expires != nil && !now.Before(*key.Expires) {
+    return nil, ErrExpired
+  }
+  return key, nil
+}
+
+/**
+ * Resolve the tier of a key
+ */
+func (k *Keys) tier(t string) (string, error) {
+  if t == "" {
+    t = k.opts.DefaultTier
+  }
+  if len(k.opts.Tiers) == 0 {
+    return t, nil
+  }
+  for _, e := range k.opts.Tiers {
+    if e == t {
+      return t, nil
+    }
+  }
+  return "", rest.NewErrorf(http.StatusBadRequest, "No such tier: %q", t)
+}
+
+/**
+ * Obtain the rate-limit tier of a principal authenticated with a key; the
+ * result is empty if it was not or the key has no tier
+ */
+func Tier(p *rest.Principal) string {
+  if p == nil {
+    return ""
+  }
+  t, _ := p.Claims[ClaimTier].(string)
+  return t
+}
+
+/**
+ * Determine when a key stops being valid; the result is zero if it does not
+ */
+func expires(k *Key) time.Time {
+  var t time.Time
+  if k.Expires != nil {
+    t = *k.Expires
+  }
+  if k.Revoked != nil && (t.IsZero() || k.Revoked.Before(t)) {
+    t = *k.Revoked
+  }
+  return t
+}
+
+/**
+ * Hash a key
+ */
+func hashKey(token string) string {
+  h := sha256.Sum256([]byte(token))
+  return hex.EncodeToString(h[:])
+}
+
+/**
+ * Produce n random bytes, hex-encoded
+ */
+func randomHex(n int) (string, error) {
+  b := make([]byte, n)
+  if _, err := rand.Read(b); err != nil {
+    return "", fmt.Errorf("Could not generate key: %v", err)
+  }
+  return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,214 @@
+package apikey
+
+import (
+  "os"
+  "sort"
+  "sync"
+  "time"
+  "io/ioutil"
+  "path/filepath"
+  "encoding/json"
+)
+
+/**
+ * An API key. The secret part of the key is never stored; only its hash
+ * is, so a key cannot be recovered once it has been issued.
+ */
+type Key struct {
+  Id      string      `json:"id"`
+  Prefix  string      `json:"prefix"`           // the leading, non-secret part of the key, by which it can be recognized
+  Hash    string      `json:"hash,omitempty"`   // the hex-encoded SHA-256 hash of the key
+  Name    string      `json:"name,omitempty"`
+  Subject string      `json:"subject"`          // the subject of the principal the key authenticates
+  Roles   []string    `json:"roles,omitempty"`
+  Scopes  []string    `json:"scopes,omitempty"`
+  Tier    string      `json:"tier,omitempty"`   // the rate-limit tier of the key
+  Created time.Time   `json:"created"`
+  Expires *time.Time  `json:"expires,omitempty"`
+  Revoked *time.Time  `json:"revoked,omitempty"`
+}
+
+/**
+ * Determine if the key is usable at a time
+ */
+func (k *Key) Valid(now time.Time) bool {
+  if k.Revoked != nil && !now.Before(*k.Revoked) {
+    return false
+  }
+  if k.Expires != nil && !now.Before(*k.Expires) {
+    return false
+  }
+  return true
+}
+
+/**
+ * Produce a copy of the key without its hash, which may be disclosed
+ */
+func (k *Key) Public() *Key {
+  c := *k
+  c.Hash = ""
+  return &c
+}
+
+/**
+ * A key store
+ */
+type KeyStore interface {
+  // Obtain a key by its identifier; the result is nil if there is no such key
+  Get(id string)(*Key, error)
+  // List every key, including those which have expired or been revoked
+  List()([]*Key, error)
+  // Create or replace a key
+  Put(k *Key)(error)
+  // Delete a key
+  Delete(id string)(error)
+}
+
+/**
+ * An in-memory key store
+ */
+type MemoryStore struct {
+  sync.RWMutex
+  keys map[string]*Key
+}
+
+/**
+ * Create a memory store
+ */
+func NewMemoryStore() *MemoryStore {
+  return &MemoryStore{keys:make(map[string]*Key)}
+}
+
+/**
+ * Obtain a key
+ */
+func (s *MemoryStore) Get(id string) (*Key, error) {
+  s.RLock()
+  defer s.RUnlock()
+  if k, ok := s.keys[id]; ok {
+    c := *k
+    return &c, nil
+  }
+  return nil, nil
+}
+
+/**
+ * List every key, ordered by creation
+ */
+func (s *MemoryStore) List() ([]*Key, error) {
+  s.RLock()
+  defer s.RUnlock()
+  l := make([]*Key, 0, len(s.keys))
+  for _, k := range s.keys {
+    c := *k
+    l = append(l, &c)
+  }
+  sort.Slice(l, func(i, j int) bool {
+    if l[i].Created.Equal(l[j].Created) {
+      return l[i].Id < l[j].Id
+    }
+    return l[i].Created.Before(l[j].Created)
+  })
+  return l, nil
+}
+
+/**
+ * Store a key
+ */
+func (s *MemoryStore) Put(k *Key) error {
+  s.Lock()
+  defer s.Unlock()
+  c := *k
+  s.keys[k.Id] = &c
+  return nil
+}
+
+/**
+ * Delete a key
+ */
+func (s *MemoryStore) Delete(id string) error {
+  s.Lock()
+  defer s.Unlock()
+  delete(s.keys, id)
+  return nil
+}
+
+/**
+ * A key store persisted to a JSON file. Keys are held in memory and the
+ * file is rewritten whenever they change; the file should not be modified
+ * by anything else while the store is in use.
+ */
+type FileStore struct {
+  *MemoryStore
+  path string
+  wlock sync.Mutex
+}
+
+/**
+ * Open a file store, loading the keys in the file if it exists
+ */
+func NewFileStore(path string) (*FileStore, error) {
+  s := &FileStore{MemoryStore:NewMemoryStore(), path:path}
+  data, err := ioutil.ReadFile(path)
+  if os.IsNotExist(err) {
+    return s, nil
+  }else if err != nil {
+    return nil, err
+  }
+  var keys []*Key
+  if err := json.Unmarshal(data, &keys); err != nil {
+    return nil, err
+  }
+  for _, e := range keys {
+    s.keys[e.Id] = e
+  }
+  return s, nil
+}
+
+/**
+ * Store a key and persist the store
+ */
+func (s *FileStore) Put(k *Key) error {
+  s.wlock.Lock()
+  defer s.wlock.Unlock()
+  s.MemoryStore.Put(k)
+  return s.save()
+}
+
+/**
+ * Delete a key and persist the store
+ */
+func (s *FileStore) Delete(id string) error {
+  s.wlock.Lock()
+  defer s.wlock.Unlock()
+  s.MemoryStore.Delete(id)
+  return s.save()
+}
+
+/**
+ * Write the store to its file. The file is replaced atomically so that it
+ * is never left partially written.
+ */
+func (s *FileStore) save() error {
+  keys, err := s.List()
+  if err != nil {
+    return err
+  }
+  data, err := json.MarshalIndent(keys, "", "  ")
+  if err != nil {
+    return err
+  }
+  f, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path) +".*")
+  if err != nil {
+    return err
+  }
+  defer os.Remove(f.Name())
+  if _, err := f.Write(data); err != nil {
+    f.Close()
+    return err
+  }
+  if err := f.Close(); err != nil {
+    return err
+  }
+  return os.Rename(f.Name(), s.path)
+}
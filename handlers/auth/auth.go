@@ -0,0 +1,221 @@
+package auth
+
+import (
+  "fmt"
+  "errors"
+  "strings"
+  "net/http"
+)
+
+import (
+  "github.com/bww/go-rest"
+  "github.com/bww/go-alert"
+)
+
+/**
+ * Route attributes understood by the authentication handler
+ */
+const (
+  ATTR_AUTH = "auth" // the authentication Mode of the route, or its name as a string
+)
+
+/**
+ * The request attribute under which the handler makes itself available
+ */
+const attrAuth = "auth.handler"
+
+/**
+ * An authentication mode
+ */
+type Mode string
+
+const (
+  Required  = Mode("required") // requests must be authenticated
+  Optional  = Mode("optional") // requests are authenticated if they provide credentials
+  None      = Mode("none")     // requests are not authenticated
+)
+
+/**
+ * An authenticator verifies credentials of a particular scheme
+ */
+type Authenticator interface {
+  // Authenticate a request. If the request provides no credentials for the
+  // authenticator's scheme the result is nil and no error, in which case the
+  // next authenticator is tried; if it provides invalid credentials the
+  // result is ErrInvalidCredentials, an error produced by InvalidCredentials
+  // or a 401 *rest.Error. Any other error is a failure of the authenticator.
+  Authenticate(*rest.Request)(*rest.Principal, error)
+  // Produce a WWW-Authenticate challenge, given the error which caused
+  // authentication to fail, if any. An empty challenge is not sent.
+  Challenge(error) string
+}
+
+/**
+ * Authentication options
+ */
+type Options struct {
+  Authenticators  []Authenticator // authenticators, which are tried in order
+  Default         Mode            // the mode of routes which do not specify one; defaults to Required
+}
+
+/**
+ * An authentication handler. Requests are authenticated by the first
+ * authenticator for which they provide credentials, and the principal it
+ * produces is set on the request. Requests which provide invalid
+ * credentials, or none when authentication is required, produce a 401 with
+ * a challenge for every authenticator.
+ *
+ * The handler should be used in a context pipeline so that route
+ * attributes are available to it.
+ */
+type Auth struct {
+  opts Options
+}
+
+/**
+ * Create an authentication handler
+ */
+func New(opts Options) *Auth {
+  if opts.Default == "" {
+    opts.Default = Required
+  }
+  return &Auth{opts}
+}
+
+/**
+ * Obtain the authentication handler which handled a request, if any
+ */
+func FromRequest(req *rest.Request) *Auth {
+  if req.Attrs == nil {
+    return nil
+  }
+  a, _ := req.Attrs[attrAuth].(*Auth)
+  return a
+}
+
+/**
+ * Produce the 401 for a request which is not authenticated but must be,
+ * with a challenge for every authenticator. Handlers which follow this one
+ * and require authentication where it is otherwise optional use this so
+ * that clients are told how to authenticate.
+ */
+func (a *Auth) Unauthorized() error {
+  return a.unauthorized(-1, nil)
+}
+
+/**
+ * Determine the authentication mode of a request
+ */
+func (a *Auth) mode(req *rest.Request) (Mode, error) {
+  switch v := req.Attrs[ATTR_AUTH].(type) {
+    case nil:
+      return a.opts.Default, nil
+    case Mode:
+      return v, nil
+    case string:
+      switch m := Mode(strings.ToLower(v)); m {
+        case Required, Optional, None:
+          return m, nil
+      }
+  }
+  return "", fmt.Errorf("Invalid authentication mode: %v", req.Attrs[ATTR_AUTH])
+}
+
+/**
+ * Authenticate a request, producing its principal or nil if it provides no
+ * credentials
+ */
+func (a *Auth) Authenticate(req *rest.Request) (*rest.Principal, error) {
+  for i, e := range a.opts.Authenticators {
+    p, err := e.Authenticate(req)
+    if err != nil {
+      return nil, a.unauthorized(i, err)
+    }
+    if p != nil {
+      return p, nil
+    }
+  }
+  return nil, nil
+}
+
+/**
+ * Produce an error for a request which could not be authenticated, given
+ * the index of the authenticator which rejected its credentials and why, if
+ * one did.
+ *
+ * Errors from authenticators which already have a status other than 401,
+ * such as a 500 when a key store or key set cannot be reached, are returned
+ * as-is, without a challenge, since they say nothing about the credentials.
+ * Other errors which are not ErrInvalidCredentials are taken to be failures
+ * of the authenticator and produce a 500; their cause is logged rather than
+ * sent to the client.
+ */
+func (a *Auth) unauthorized(failed int, err error) error {
+  var rerr *rest.Error
+  if x, ok := err.(*rest.Error); ok {
+    if x.Status != http.StatusUnauthorized {
+      return x
+    }
+    c := *x // copy, the authenticator's error may be shared
+    rerr = &c
+  }else if err == nil {
+    rerr = rest.NewErrorf(http.StatusUnauthorized, "Authentication required")
+  }else if errors.Is(err, ErrInvalidCredentials) {
+    if err != ErrInvalidCredentials {
+      alt.Debugf("auth: Invalid credentials: %v", err)
+    }
+    rerr = rest.NewErrorf(http.StatusUnauthorized, "%v", ErrInvalidCredentials)
+  }else{
+    alt.Errorf("auth: Could not authenticate request: %v", err)
+    return rest.NewErrorf(http.StatusInternalServerError, "Could not authenticate request")
+  }
+
+  var c []string
+  for i, e := range a.opts.Authenticators {
+    var cerr error
+    if i == failed {
+      cerr = err
+    }
+    if s := e.Challenge(cerr); s != "" {
+      c = append(c, s)
+    }
+  }
+  if len(c) > 0 {
+    h := make(map[string]string)
+    for k, v := range rerr.Headers {
+      h[k] = v
+    }
+    h["WWW-Authenticate"] = strings.Join(c, ", ")
+    rerr.SetHeaders(h)
+  }
+  return rerr
+}
+
+/**
+ * Serve a request
+ */
+func (a *Auth) ServeRequest(rsp http.ResponseWriter, req *rest.Request, pln rest.Pipeline) (interface{}, error) {
+  if req.Attrs == nil {
+    req.Attrs = make(rest.Attrs)
+  }
+  req.Attrs[attrAuth] = a
+
+  mode, err := a.mode(req)
+  if err != nil {
+    return nil, rest.NewErrorf(http.StatusInternalServerError, "%v", err)
+  }
+  if mode == None {
+    return pln.Next(rsp, req)
+  }
+
+  p, err := a.Authenticate(req)
+  if err != nil {
+    return nil, err
+  }
+  if p == nil && mode == Required {
+    return nil, a.Unauthorized()
+  }
+
+  req.Principal = p
+  return pln.Next(rsp, req)
+}
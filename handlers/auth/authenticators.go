@@ -0,0 +1,260 @@
+package auth
+
+import (
+  "fmt"
+  "errors"
+  "strings"
+)
+
+import (
+  "github.com/bww/go-rest"
+)
+
+/**
+ * Credentials were provided but are not valid
+ */
+var ErrInvalidCredentials = errors.New("Invalid credentials")
+
+/**
+ * Produce an error for credentials which are not valid for a specific
+ * reason. The error is treated as ErrInvalidCredentials, which errors.Is
+ * reports it to be; the reason is logged but not sent to the client.
+ */
+func InvalidCredentials(reason string) error {
+  return invalidCredentials(reason)
+}
+
+/**
+ * Credentials which are not valid for a specific reason
+ */
+type invalidCredentials string
+
+/**
+ * It's an error
+ */
+func (e invalidCredentials) Error() string {
+  return string(e)
+}
+
+/**
+ * It's also ErrInvalidCredentials
+ */
+func (e invalidCredentials) Is(target error) bool {
+  return target == ErrInvalidCredentials
+}
+
+/**
+ * Verify a username and password, producing the principal they identify
+ */
+type PasswordVerifier func(req *rest.Request, user, password string)(*rest.Principal, error)
+
+/**
+ * Verify a token, producing the principal it identifies
+ */
+type TokenVerifier func(req *rest.Request, token string)(*rest.Principal, error)
+
+/**
+ * HTTP Basic authentication
+ */
+type Basic struct {
+  Realm   string
+  Verify  PasswordVerifier
+}
+
+/**
+ * Authenticate a request
+ */
+func (a Basic) Authenticate(req *rest.Request) (*rest.Principal, error) {
+  user, password, ok := req.BasicAuth()
+  if !ok {
+    return nil, nil
+  }
+  p, err := a.Verify(req, user, password)
+  if err != nil {
+    return nil, err
+  }
+  if p == nil {
+    return nil, ErrInvalidCredentials
+  }
+  if p.Scheme == "" {
+    p.Scheme = "basic"
+  }
+  return p, nil
+}
+
+/**
+ * Produce a challenge
+ */
+func (a Basic) Challenge(err error) string {
+  return fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, a.Realm)
+}
+
+/**
+ * Bearer token authentication (RFC 6750)
+ */
+type Bearer struct {
+  Realm   string
+  Verify  TokenVerifier
+}
+
+/**
+ * Obtain the bearer token from a request, if it has one
+ */
+func BearerToken(req *rest.Request) (string, bool) {
+  h := req.Header.Get("Authorization")
+  if len(h) < 7 || !strings.EqualFold(h[:7], "Bearer ") {
+    return "", false
+  }
+  t := strings.TrimSpace(h[7:])
+  return t, t != ""
+}
+
+/**
+ * Authenticate a request
+ */
+func (a Bearer) Authenticate(req *rest.Request) (*rest.Principal, error) {
+  t, ok := BearerToken(req)
+  if !ok {
+    return nil, nil
+  }
+  p, err := a.Verify(req, t)
+  if err != nil {
+    return nil, err
+  }
+  if p == nil {
+    return nil, ErrInvalidCredentials
+  }
+  if p.Scheme == "" {
+    p.Scheme = "bearer"
+  }
+  return p, nil
+}
+
+/**
+ * Produce a challenge; the error is described when there is one
+ */
+func (a Bearer) Challenge(err error) string {
+  return BearerChallenge(a.Realm, err)
+}
+
+/**
+ * Produce a Bearer challenge for a realm and the error which caused
+ * authentication to fail, if any. The error is described with a fixed
+ * message, since it may describe more than the client should know.
+ */
+func BearerChallenge(realm string, err error) string {
+  c := fmt.Sprintf("Bearer realm=%q", realm)
+  if err != nil {
+    c += fmt.Sprintf(`, error="invalid_token", error_description=%q`, tokenErrorDescription(err))
+  }
+  return c
+}
+
+/**
+ * Describe why a token was rejected
+ */
+func tokenErrorDescription(err error) string {
+  if rerr, ok := err.(*rest.Error); ok {
+    if t, ok := rerr.Detail.(TokenError); ok {
+      switch t.Code {
+        case TokenExpired:
+          return "The token has expired"
+        case TokenNotYetValid:
+          return "The token is not yet valid"
+      }
+    }
+  }
+  return "The token is invalid"
+}
+
+/**
+ * API key authentication. The key is read from a header or, if the
+ * authenticator has no header or the request does not provide it, a query
+ * parameter. Keys in query parameters are prone to being logged and
+ * should be avoided where possible.
+ */
+type APIKey struct {
+  Header  string // the header which provides the key, e.g., X-API-Key
+  Query   string // the query parameter which provides the key, if any
+  Verify  TokenVerifier
+}
+
+/**
+ * Obtain the key from a request
+ */
+func (a APIKey) key(req *rest.Request) string {
+  if a.Header != "" {
+    if k := req.Header.Get(a.Header); k != "" {
+      return k
+    }
+  }
+  if a.Query != "" {
+    return req.URL.Query().Get(a.Query)
+  }
+  return ""
+}
+
+/**
+ * Authenticate a request
+ */
+func (a APIKey) Authenticate(req *rest.Request) (*rest.Principal, error) {
+  k := a.key(req)
+  if k == "" {
+    return nil, nil
+  }
+  p, err := a.Verify(req, k)
+  if err != nil {
+    return nil, err
+  }
+  if p == nil {
+    return nil, ErrInvalidCredentials
+  }
+  if p.Scheme == "" {
+    p.Scheme = "apikey"
+  }
+  return p, nil
+}
+
+/**
+ * API keys have no standard challenge
+ */
+func (a APIKey) Challenge(err error) string {
+  return ""
+}
+
+/**
+ * Cookie session authentication. The cookie value is verified, typically
+ * by looking up the session it identifies.
+ */
+type Cookie struct {
+  Name    string
+  Verify  TokenVerifier
+}
+
+/**
+ * Authenticate a request
+ */
+func (a Cookie) Authenticate(req *rest.Request) (*rest.Principal, error) {
+  c, err := req.Cookie(a.Name)
+  if err != nil || c.Value == "" {
+    return nil, nil
+  }
+  p, err := a.Verify(req, c.Value)
+  if err != nil {
+    return nil, err
+  }
+  if p == nil {
+    return nil, ErrInvalidCredentials
+  }
+  if p.Scheme == "" {
+    p.Scheme = "cookie"
+  }
+  return p, nil
+}
+
+/**
+ * Cookies have no challenge
+ */
+func (a Cookie) Challenge(err error) string {
+  return ""
+}
@@ -0,0 +1,302 @@
+package auth
+
+import (
+  "fmt"
+  "sync"
+  "time"
+  "strings"
+  "math/big"
+  "io/ioutil"
+  "net/http"
+  "crypto/rsa"
+  "crypto/ecdsa"
+  "crypto/ed25519"
+  "crypto/elliptic"
+  "encoding/json"
+  "encoding/base64"
+)
+
+import (
+  "github.com/bww/go-alert"
+)
+
+/**
+ * The minimum interval between refreshes of a key set triggered by tokens
+ * signed with unknown keys
+ */
+const minJWKSRefresh = 30 * time.Second
+
+/**
+ * A set of keys used to verify tokens. Keys are []byte for HMAC algorithms,
+ * *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey.
+ */
+type KeySet interface {
+  // Obtain the key with an identifier; the identifier may be empty if the
+  // token does not specify one. The result is nil if there is no such key.
+  Key(kid string)(interface{}, error)
+}
+
+/**
+ * Keys which do not change, by identifier. If a token does not identify
+ * its key and there is only one key, that key is used.
+ */
+type StaticKeys map[string]interface{}
+
+/**
+ * Obtain a key
+ */
+func (s StaticKeys) Key(kid string) (interface{}, error) {
+  if k, ok := s[kid]; ok {
+    return k, nil
+  }
+  if kid == "" && len(s) == 1 {
+    for _, k := range s {
+      return k, nil
+    }
+  }
+  return nil, nil
+}
+
+/**
+ * A JSON Web Key Set (RFC 7517) loaded from a file or URL and refreshed
+ * periodically. The set is also refreshed when a token identifies a key it
+ * does not contain, so keys can be rotated without waiting for the refresh
+ * interval; such refreshes are attempted at most every 30 seconds, whether
+ * or not they succeed.
+ */
+type JWKS struct {
+  sync.RWMutex
+  source    string
+  client    *http.Client
+  keys      map[string]interface{}
+  attempted time.Time
+  stop      chan struct{}
+}
+
+/**
+ * Load a key set from a file or an http(s) URL, refreshing it at the
+ * specified interval; an interval of zero disables periodic refreshes
+ */
+func NewJWKS(source string, refresh time.Duration) (*JWKS, error) {
+  j := &JWKS{source:source, client:&http.Client{Timeout:10 * time.Second}, stop:make(chan struct{})}
+  if err := j.Refresh(); err != nil {
+    return nil, err
+  }
+  if refresh > 0 {
+    go j.refresh(refresh)
+  }
+  return j, nil
+}
+
+/**
+ * Refresh the key set periodically until it is closed
+ */
+func (j *JWKS) refresh(d time.Duration) {
+  t := time.NewTicker(d)
+  defer t.Stop()
+  for {
+    select {
+      case <-j.stop:
+        return
+      case <-t.C:
+        if err := j.Refresh(); err != nil {
+          alt.Errorf("auth: Could not refresh keys from %v: %v", j.source, err)
+        }
+    }
+  }
+}
+
+/**
+ * Stop refreshing the key set
+ */
+func (j *JWKS) Close() {
+  close(j.stop)
+}
+
+/**
+ * Reload the key set. If it cannot be loaded the current keys remain in
+ * effect.
+ */
+func (j *JWKS) Refresh() error {
+  j.Lock()
+  j.attempted = time.Now()
+  j.Unlock()
+  data, err := j.read()
+  if err != nil {
+    return err
+  }
+  keys, err := ParseJWKS(data)
+  if err != nil {
+    return err
+  }
+  j.Lock()
+  j.keys = keys
+  j.Unlock()
+  return nil
+}
+
+/**
+ * Read the key set document
+ */
+func (j *JWKS) read() ([]byte, error) {
+  if !strings.HasPrefix(j.source, "http://") && !strings.HasPrefix(j.source, "https://") {
+    return ioutil.ReadFile(j.source)
+  }
+  rsp, err := j.client.Get(j.source)
+  if err != nil {
+    return nil, err
+  }
+  defer rsp.Body.Close()
+  if rsp.StatusCode != http.StatusOK {
+    return nil, fmt.Errorf("Unexpected status fetching keys: %v", rsp.Status)
+  }
+  return ioutil.ReadAll(rsp.Body)
+}
+
+/**
+ * Obtain a key. If the key is unknown and the set cannot be refreshed the
+ * result is nil, so the token is rejected as having an unknown key.
+ */
+func (j *JWKS) Key(kid string) (interface{}, error) {
+  j.RLock()
+  key, _ := StaticKeys(j.keys).Key(kid)
+  recent := time.Since(j.attempted) < minJWKSRefresh
+  j.RUnlock()
+  if key != nil || recent {
+    return key, nil
+  }
+
+  j.Lock()
+  if time.Since(j.attempted) < minJWKSRefresh { // another request claimed the refresh first
+    key, _ = StaticKeys(j.keys).Key(kid)
+    j.Unlock()
+    return key, nil
+  }
+  j.attempted = time.Now() // claim the refresh so concurrent requests don't also attempt it
+  j.Unlock()
+
+  if err := j.Refresh(); err != nil {
+    alt.Errorf("auth: Could not refresh keys from %v: %v", j.source, err)
+    return nil, nil
+  }
+  j.RLock()
+  defer j.RUnlock()
+  return StaticKeys(j.keys).Key(kid)
+}
+
+/**
+ * A JSON Web Key
+ */
+type jwk struct {
+  Kty string `json:"kty"`
+  Kid string `json:"kid"`
+  Use string `json:"use"`
+  Crv string `json:"crv"`
+  N   string `json:"n"`
+  E   string `json:"e"`
+  X   string `json:"x"`
+  Y   string `json:"y"`
+  K   string `json:"k"`
+}
+
+/**
+ * Parse a JSON Web Key Set into keys by identifier. Keys which are not for
+ * signatures, or are of unsupported types, are ignored.
+ */
+func ParseJWKS(data []byte) (map[string]interface{}, error) {
+  var set struct {
+    Keys []jwk `json:"keys"`
+  }
+  if err := json.Unmarshal(data, &set); err != nil {
+    return nil, fmt.Errorf("Invalid key set: %v", err)
+  }
+  keys := make(map[string]interface{})
+  for _, e := range set.Keys {
+    if e.Use != "" && e.Use != "sig" {
+      continue
+    }
+    k, err := e.key()
+    if err != nil {
+      return nil, fmt.Errorf("Invalid key %q: %v", e.Kid, err)
+    }
+    if k != nil {
+      keys[e.Kid] = k
+    }
+  }
+  return keys, nil
+}
+
+/**
+ * Produce the key described by a JWK
+ */
+func (k jwk) key() (interface{}, error) {
+  switch k.Kty {
+    case "RSA":
+      n, err := decodeBigInt(k.N)
+      if err != nil {
+        return nil, err
+      }
+      e, err := decodeBigInt(k.E)
+      if err != nil {
+        return nil, err
+      }
+      if !e.IsInt64() || e.Int64() > 1 << 31 {
+        return nil, fmt.Errorf("Invalid exponent")
+      }
+      return &rsa.PublicKey{N:n, E:int(e.Int64())}, nil
+    case "EC":
+      var curve elliptic.Curve
+      switch k.Crv {
+        case "P-256":
+          curve = elliptic.P256()
+        case "P-384":
+          curve = elliptic.P384()
+        case "P-521":
+          curve = elliptic.P521()
+        default:
+          return nil, nil
+      }
+      x, err := decodeBigInt(k.X)
+      if err != nil {
+        return nil, err
+      }
+      y, err := decodeBigInt(k.Y)
+      if err != nil {
+        return nil, err
+      }
+      if !curve.IsOnCurve(x, y) {
+        return nil, fmt.Errorf("Point is not on curve")
+      }
+      return &ecdsa.PublicKey{Curve:curve, X:x, Y:y}, nil
+    case "OKP":
+      if k.Crv != "Ed25519" {
+        return nil, nil
+      }
+      x, err := base64.RawURLEncoding.DecodeString(k.X)
+      if err != nil {
+        return nil, err
+      }
+      if len(x) != ed25519.PublicKeySize {
+        return nil, fmt.Errorf("Invalid key size")
+      }
+      return ed25519.PublicKey(x), nil
+    case "oct":
+      return base64.RawURLEncoding.DecodeString(k.K)
+    default:
+      return nil, nil
+  }
+}
+
+/**
+ * Decode a base64url-encoded big-endian integer
+ */
+func decodeBigInt(s string) (*big.Int, error) {
+  b, err := base64.RawURLEncoding.DecodeString(s)
+  if err != nil {
+    return nil, err
+  }
+  if len(b) == 0 {
+    return nil, fmt.Errorf("Empty integer")
+  }
+  return new(big.Int).SetBytes(b), nil
+}
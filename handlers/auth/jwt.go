@@ -0,0 +1,350 @@
+package auth
+
+import (
+  "fmt"
+  "time"
+  "bytes"
+  "strings"
+  "math/big"
+  "net/http"
+  "crypto"
+  "crypto/hmac"
+  "crypto/rsa"
+  "crypto/ecdsa"
+  "crypto/sha256"
+  "crypto/ed25519"
+  "encoding/json"
+  "encoding/base64"
+)
+
+import (
+  "github.com/bww/go-rest"
+)
+
+/**
+ * The default clock skew permitted when checking token times
+ */
+const DefaultSkew = time.Minute
+
+/**
+ * Token rejection codes
+ */
+const (
+  TokenMalformed            = "malformed"
+  TokenUnsupportedAlgorithm = "unsupported_algorithm"
+  TokenUnknownKey           = "unknown_key"
+  TokenInvalidSignature     = "invalid_signature"
+  TokenExpired              = "expired"
+  TokenNotYetValid          = "not_yet_valid"
+  TokenInvalidIssuer        = "invalid_issuer"
+  TokenInvalidAudience      = "invalid_audience"
+  TokenInvalidClaims        = "invalid_claims"
+)
+
+/**
+ * The detail of a rejected token
+ */
+type TokenError struct {
+  Code    string `json:"code"`
+  Message string `json:"message"`
+}
+
+/**
+ * It's an error
+ */
+func (e TokenError) Error() string {
+  return e.Message
+}
+
+/**
+ * Produce a 401 for a rejected token
+ */
+func tokenError(code, f string, a ...interface{}) *rest.Error {
+  m := fmt.Sprintf(f, a...)
+  return rest.NewErrorf(http.StatusUnauthorized, "Invalid token: %s", m).SetDetail(TokenError{code, m})
+}
+
+/**
+ * JWT options
+ */
+type JWTOptions struct {
+  Realm       string
+  Keys        KeySet        // the keys with which tokens are verified
+  Algorithms  []string      // permitted algorithms; defaults to HS256, RS256, ES256 and EdDSA
+  Issuer      string        // if set, tokens must be issued by this issuer
+  Audience    string        // if set, tokens must be intended for this audience
+  Skew        time.Duration // the clock skew permitted; defaults to DefaultSkew
+  Claims      func(map[string]interface{}, *rest.Principal) error // map claims into the principal, after the standard mapping
+}
+
+/**
+ * A JWT bearer token authenticator. Tokens signed with HS256, RS256, ES256
+ * or EdDSA are verified and their exp, nbf, iss and aud claims checked.
+ *
+ * The principal's subject is the sub claim; its scopes are the scope claim,
+ * a space-separated string, or the scp claim; its roles are the roles
+ * claim; and its claims are all of the token's claims, with numbers as
+ * json.Number values. Rejected tokens produce a 401 whose detail is a
+ * TokenError.
+ */
+type JWT struct {
+  opts JWTOptions
+}
+
+/**
+ * Create a JWT authenticator
+ */
+func NewJWT(opts JWTOptions) *JWT {
+  if len(opts.Algorithms) == 0 {
+    opts.Algorithms = []string{"HS256", "RS256", "ES256", "EdDSA"}
+  }
+  if opts.Skew == 0 {
+    opts.Skew = DefaultSkew
+  }
+  return &JWT{opts}
+}
+
+/**
+ * Authenticate a request
+ */
+func (j *JWT) Authenticate(req *rest.Request) (*rest.Principal, error) {
+  t, ok := BearerToken(req)
+  if !ok {
+    return nil, nil
+  }
+  claims, err := j.Verify(t)
+  if err != nil {
+    return nil, err
+  }
+  return j.principal(claims)
+}
+
+/**
+ * Produce a challenge
+ */
+func (j *JWT) Challenge(err error) string {
+  return BearerChallenge(j.opts.Realm, err)
+}
+
+/**
+ * Verify a token and produce its claims
+ */
+func (j *JWT) Verify(token string) (map[string]interface{}, error) {
+  parts := strings.Split(token, ".")
+  if len(parts) != 3 {
+    return nil, tokenError(TokenMalformed, "Token must have three parts")
+  }
+
+  var header struct {
+    Alg string `json:"alg"`
+    Kid string `json:"kid"`
+  }
+  if err := decodeSegment(parts[0], &header); err != nil {
+    return nil, tokenError(TokenMalformed, "Invalid header: %v", err)
+  }
+  if !j.permits(header.Alg) {
+    return nil, tokenError(TokenUnsupportedAlgorithm, "Unsupported algorithm: %v", header.Alg)
+  }
+
+  key, err := j.opts.Keys.Key(header.Kid)
+  if err != nil {
+    return nil, rest.NewErrorf(http.StatusInternalServerError, "Could not obtain verification key: %v", err)
+  }
+  if key == nil {
+    return nil, tokenError(TokenUnknownKey, "Unknown key: %q", header.Kid)
+  }
+
+  sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+  if err != nil {
+    return nil, tokenError(TokenMalformed, "Invalid signature encoding")
+  }
+  if err := verifySignature(header.Alg, key, []byte(parts[0] +"."+ parts[1]), sig); err != nil {
+    return nil, tokenError(TokenInvalidSignature, "%v", err)
+  }
+
+  var claims map[string]interface{}
+  if err := decodeSegment(parts[1], &claims); err != nil {
+    return nil, tokenError(TokenMalformed, "Invalid claims: %v", err)
+  }
+  if err := j.checkClaims(claims); err != nil {
+    return nil, err
+  }
+  return claims, nil
+}
+
+/**
+ * Determine if an algorithm is permitted
+ */
+func (j *JWT) permits(alg string) bool {
+  for _, e := range j.opts.Algorithms {
+    if e == alg {
+      return true
+    }
+  }
+  return false
+}
+
+/**
+ * Check the registered claims of a token
+ */
+func (j *JWT) checkClaims(c map[string]interface{}) error {
+  now := time.Now()
+  if v, ok := c["exp"]; ok {
+    exp, ok := numericDate(v)
+    if !ok {
+      return tokenError(TokenInvalidClaims, "Invalid exp claim")
+    }
+    if now.After(exp.Add(j.opts.Skew)) {
+      return tokenError(TokenExpired, "Token expired at %v", exp.UTC().Format(time.RFC3339))
+    }
+  }
+  if v, ok := c["nbf"]; ok {
+    nbf, ok := numericDate(v)
+    if !ok {
+      return tokenError(TokenInvalidClaims, "Invalid nbf claim")
+    }
+    if now.Add(j.opts.Skew).Before(nbf) {
+      return tokenError(TokenNotYetValid, "Token is not valid until %v", nbf.UTC().Format(time.RFC3339))
+    }
+  }
+  if j.opts.Issuer != "" {
+    if iss, _ := c["iss"].(string); iss != j.opts.Issuer {
+      return tokenError(TokenInvalidIssuer, "Unexpected issuer: %q", iss)
+    }
+  }
+  if j.opts.Audience != "" && !hasMember(c["aud"], j.opts.Audience) {
+    return tokenError(TokenInvalidAudience, "Token is not intended for this audience")
+  }
+  return nil
+}
+
+/**
+ * Produce the principal identified by claims
+ */
+func (j *JWT) principal(c map[string]interface{}) (*rest.Principal, error) {
+  p := &rest.Principal{Scheme:"jwt", Claims:c}
+  p.Subject, _ = c["sub"].(string)
+  if s, ok := c["scope"].(string); ok {
+    p.Scopes = strings.Fields(s)
+  }else{
+    p.Scopes = stringList(c["scp"])
+  }
+  p.Roles = stringList(c["roles"])
+  if v, ok := c["exp"]; ok {
+    p.Expires, _ = numericDate(v)
+  }
+  if f := j.opts.Claims; f != nil {
+    if err := f(c, p); err != nil {
+      return nil, tokenError(TokenInvalidClaims, "%v", err)
+    }
+  }
+  return p, nil
+}
+
+/**
+ * Verify a signature
+ */
+func verifySignature(alg string, key interface{}, input, sig []byte) error {
+  var ok bool
+  switch alg {
+    case "HS256":
+      k, isbytes := key.([]byte)
+      if !isbytes {
+        return fmt.Errorf("Key is not suitable for %v", alg)
+      }
+      m := hmac.New(sha256.New, k)
+      m.Write(input)
+      ok = hmac.Equal(sig, m.Sum(nil))
+    case "RS256":
+      k, isrsa := key.(*rsa.PublicKey)
+      if !isrsa {
+        return fmt.Errorf("Key is not suitable for %v", alg)
+      }
+      h := sha256.Sum256(input)
+      ok = rsa.VerifyPKCS1v15(k, crypto.SHA256, h[:], sig) == nil
+    case "ES256":
+      k, isec := key.(*ecdsa.PublicKey)
+      if !isec || k.Curve.Params().Name != "P-256" {
+        return fmt.Errorf("Key is not suitable for %v", alg)
+      }
+      if len(sig) != 64 {
+        return fmt.Errorf("Invalid signature length")
+      }
+      h := sha256.Sum256(input)
+      ok = ecdsa.Verify(k, h[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:]))
+    case "EdDSA":
+      k, ised := key.(ed25519.PublicKey)
+      if !ised {
+        return fmt.Errorf("Key is not suitable for %v", alg)
+      }
+      ok = ed25519.Verify(k, input, sig)
+    default:
+      return fmt.Errorf("Unsupported algorithm: %v", alg)
+  }
+  if !ok {
+    return fmt.Errorf("Signature is not valid")
+  }
+  return nil
+}
+
+/**
+ * Decode a base64url-encoded JSON segment
+ */
+func decodeSegment(s string, v interface{}) error {
+  data, err := base64.RawURLEncoding.DecodeString(s)
+  if err != nil {
+    return err
+  }
+  dec := json.NewDecoder(bytes.NewReader(data))
+  dec.UseNumber()
+  return dec.Decode(v)
+}
+
+/**
+ * Interpret a NumericDate claim
+ */
+func numericDate(v interface{}) (time.Time, bool) {
+  n, ok := v.(json.Number)
+  if !ok {
+    return time.Time{}, false
+  }
+  f, err := n.Float64()
+  if err != nil {
+    return time.Time{}, false
+  }
+  sec := int64(f)
+  return time.Unix(sec, int64((f - float64(sec)) * 1e9)), true
+}
+
+/**
+ * Interpret a claim which may be a string or a list of strings
+ */
+func stringList(v interface{}) []string {
+  switch c := v.(type) {
+    case string:
+      return []string{c}
+    case []interface{}:
+      var l []string
+      for _, e := range c {
+        if s, ok := e.(string); ok {
+          l = append(l, s)
+        }
+      }
+      return l
+    default:
+      return nil
+  }
+}
+
+/**
+ * Determine if a claim which may be a string or a list of strings contains
+ * a value
+ */
+func hasMember(v interface{}, s string) bool {
+  for _, e := range stringList(v) {
+    if e == s {
+      return true
+    }
+  }
+  return false
+}
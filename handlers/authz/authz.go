@@ -0,0 +1,293 @@
+package authz
+
+import (
+  "reflect"
+  "runtime"
+  "strings"
+  "net/http"
+)
+
+import (
+  "github.com/bww/go-rest"
+  "github.com/bww/go-rest/handlers/auth"
+)
+
+/**
+ * Route attributes understood by the authorization handler. Roles, scopes
+ * and permissions may be a string or a []string.
+ */
+const (
+  ATTR_ROLES        = "authz.roles"       // roles, any one of which grants access to the route
+  ATTR_SCOPES       = "authz.scopes"      // scopes, all of which are required to access the route
+  ATTR_PERMISSIONS  = "authz.permissions" // permissions, all of which are required to access the route
+  ATTR_POLICY       = "authz.policy"      // a Policy or the name of one, or a []interface{} of them, all of which must allow access
+)
+
+/**
+ * A policy decides whether the principal of a request, which may be nil,
+ * may access the resource it addresses. It returns nil to allow access.
+ * Errors which are not *rest.Errors deny access with a 403.
+ */
+type Policy func(*rest.Request) error
+
+/**
+ * Produce an error which denies access
+ */
+func Deny(f string, a ...interface{}) error {
+  return rest.NewErrorf(http.StatusForbidden, f, a...)
+}
+
+/**
+ * Authorization options
+ */
+type Options struct {
+  Permissions map[string][]string // the permissions granted by each role
+  Policies    map[string]Policy   // policies, by the names routes refer to them by
+}
+
+/**
+ * The detail of a denied request
+ */
+type Denial struct {
+  Requirement string    `json:"requirement"` // one of role, scope, permission or policy
+  Missing     []string  `json:"missing"`
+}
+
+/**
+ * An authorization handler. Requests are authorized against the
+ * requirements in their route attributes: the principal must have one of
+ * the route's roles, if it has any, and all of its scopes and permissions,
+ * and every policy must allow the request. A principal's permissions are
+ * those granted by its roles.
+ *
+ * Requests to routes with requirements are denied with a 401 if they are
+ * not authenticated, so this handler should follow the authentication
+ * handler, whose challenge the 401 carries, and with a 403 whose detail is
+ * a Denial otherwise. The handler
+ * should be used in a context pipeline so that route attributes are
+ * available to it.
+ */
+type Authz struct {
+  opts Options
+}
+
+/**
+ * Create an authorization handler
+ */
+func New(opts Options) *Authz {
+  return &Authz{opts}
+}
+
+/**
+ * Obtain the permissions granted to a principal
+ */
+func (a *Authz) Permissions(p *rest.Principal) map[string]bool {
+  perms := make(map[string]bool)
+  if p != nil {
+    for _, r := range p.Roles {
+      for _, e := range a.opts.Permissions[r] {
+        perms[e] = true
+      }
+    }
+  }
+  return perms
+}
+
+/**
+ * Authorize a request
+ */
+func (a *Authz) Authorize(req *rest.Request) error {
+  roles, err := stringsAttr(req.Attrs, ATTR_ROLES)
+  if err != nil {
+    return err
+  }
+  scopes, err := stringsAttr(req.Attrs, ATTR_SCOPES)
+  if err != nil {
+    return err
+  }
+  perms, err := stringsAttr(req.Attrs, ATTR_PERMISSIONS)
+  if err != nil {
+    return err
+  }
+  policies, err := a.policies(req.Attrs[ATTR_POLICY])
+  if err != nil {
+    return err
+  }
+
+  p := req.Principal
+  if p == nil && (len(roles) > 0 || len(scopes) > 0 || len(perms) > 0) {
+    return unauthenticated(req)
+  }
+
+  if len(roles) > 0 {
+    ok := false
+    for _, e := range roles {
+      if p.HasRole(e) {
+        ok = true
+        break
+      }
+    }
+    if !ok {
+      return denied("role", roles, "Requires one of roles: %v", strings.Join(roles, ", "))
+    }
+  }
+
+  var missing []string
+  for _, e := range scopes {
+    if !p.HasScope(e) {
+      missing = append(missing, e)
+    }
+  }
+  if len(missing) > 0 {
+    return denied("scope", missing, "Missing required scopes: %v", strings.Join(missing, ", "))
+  }
+
+  granted := a.Permissions(p)
+  for _, e := range perms {
+    if !granted[e] {
+      missing = append(missing, e)
+    }
+  }
+  if len(missing) > 0 {
+    return denied("permission", missing, "Missing required permissions: %v", strings.Join(missing, ", "))
+  }
+
+  for _, e := range policies {
+    if err := e.policy(req); err != nil {
+      if x, ok := err.(*rest.Error); ok {
+        rerr := *x // copy, the policy's error may be shared
+        if rerr.Status == http.StatusForbidden && rerr.Detail == nil {
+          rerr.SetDetail(Denial{"policy", []string{e.name}})
+        }else if rerr.Status == http.StatusUnauthorized && rerr.Headers["WWW-Authenticate"] == "" {
+          rerr.SetHeaders(challenge(rerr.Headers, req))
+        }
+        return &rerr
+      }
+      return denied("policy", []string{e.name}, "Denied by policy %v: %v", e.name, err)
+    }
+  }
+
+  return nil
+}
+
+/**
+ * Produce a 401 for a request which must be authenticated, with the
+ * challenge of the authentication handler if there is one
+ */
+func unauthenticated(req *rest.Request) error {
+  if a := auth.FromRequest(req); a != nil {
+    return a.Unauthorized()
+  }
+  return rest.NewErrorf(http.StatusUnauthorized, "Authentication required")
+}
+
+/**
+ * Add the challenge of the authentication handler, if there is one, to a
+ * copy of the headers of a 401
+ */
+func challenge(h map[string]string, req *rest.Request) map[string]string {
+  c := make(map[string]string)
+  for k, v := range h {
+    c[k] = v
+  }
+  if rerr, ok := unauthenticated(req).(*rest.Error); ok {
+    if v := rerr.Headers["WWW-Authenticate"]; v != "" {
+      c["WWW-Authenticate"] = v
+    }
+  }
+  return c
+}
+
+/**
+ * Produce a 403 for a missing requirement
+ */
+func denied(req string, missing []string, f string, a ...interface{}) error {
+  return rest.NewErrorf(http.StatusForbidden, f, a...).SetDetail(Denial{req, missing})
+}
+
+/**
+ * A policy and the name by which it is described
+ */
+type namedPolicy struct {
+  name    string
+  policy  Policy
+}
+
+/**
+ * Resolve the policies in an attribute
+ */
+func (a *Authz) policies(v interface{}) ([]namedPolicy, error) {
+  switch c := v.(type) {
+    case nil:
+      return nil, nil
+    case Policy:
+      return []namedPolicy{{funcName(c), c}}, nil
+    case func(*rest.Request) error:
+      return []namedPolicy{{funcName(c), c}}, nil
+    case string:
+      p, ok := a.opts.Policies[c]
+      if !ok {
+        return nil, rest.NewErrorf(http.StatusInternalServerError, "No such policy: %v", c)
+      }
+      return []namedPolicy{{c, p}}, nil
+    case []string:
+      var l []namedPolicy
+      for _, e := range c {
+        p, err := a.policies(e)
+        if err != nil {
+          return nil, err
+        }
+        l = append(l, p...)
+      }
+      return l, nil
+    case []interface{}:
+      var l []namedPolicy
+      for _, e := range c {
+        p, err := a.policies(e)
+        if err != nil {
+          return nil, err
+        }
+        l = append(l, p...)
+      }
+      return l, nil
+    default:
+      return nil, rest.NewErrorf(http.StatusInternalServerError, "Unsupported policy attribute type: %T", v)
+  }
+}
+
+/**
+ * Describe a policy function by its unqualified name
+ */
+func funcName(f interface{}) string {
+  if r := runtime.FuncForPC(reflect.ValueOf(f).Pointer()); r != nil {
+    n := r.Name()
+    return n[strings.LastIndex(n, "/")+1:]
+  }
+  return "policy"
+}
+
+/**
+ * Obtain a list of strings from an attribute
+ */
+func stringsAttr(a rest.Attrs, k string) ([]string, error) {
+  switch v := a[k].(type) {
+    case nil:
+      return nil, nil
+    case string:
+      return []string{v}, nil
+    case []string:
+      return v, nil
+    default:
+      return nil, rest.NewErrorf(http.StatusInternalServerError, "Unsupported %v attribute type: %T", k, v)
+  }
+}
+
+/**
+ * Serve a request
+ */
+func (a *Authz) ServeRequest(rsp http.ResponseWriter, req *rest.Request, pln rest.Pipeline) (interface{}, error) {
+  if err := a.Authorize(req); err != nil {
+    return nil, err
+  }
+  return pln.Next(rsp, req)
+}
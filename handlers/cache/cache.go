@@ -0,0 +1,481 @@
+package cache
+
+import (
+  "sync"
+  "time"
+  "strconv"
+  "strings"
+  "context"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+)
+
+import (
+  "github.com/bww/go-rest"
+)
+
+/**
+ * Route attributes understood by the cache
+ */
+const (
+  ATTR_TTL    = "cache.ttl"     // a time.Duration for which responses from the route are fresh
+  ATTR_STALE  = "cache.stale"   // a time.Duration for which stale responses may be served while they are revalidated
+  ATTR_TAGS   = "cache.tags"    // a []string of tags applied to responses from the route
+)
+
+/**
+ * The request attribute under which the cache makes itself available
+ */
+const attrCache = "cache.handler"
+
+/**
+ * Cache options
+ */
+type Options struct {
+  Store                 Store                       // the store; defaults to a memory store of DefaultCapacity entries
+  TTL                   time.Duration               // the default freshness lifetime when neither the route nor the response specify one
+  StaleWhileRevalidate  time.Duration               // the default period stale responses may be served while they are revalidated
+  Key                   func(*rest.Request)(string) // produce the base cache key for a request; defaults to the host and request URI
+}
+
+/**
+ * The default memory store capacity
+ */
+const DefaultCapacity = 1024
+
+/**
+ * A response cache handler. This handler should be used in a context
+ * pipeline so that route attributes are available to it.
+ *
+ * Responses to GET requests are cached when the handler succeeds and a
+ * freshness lifetime can be determined from the response Cache-Control
+ * header, the route attributes or the cache defaults, in that order.
+ * Responses marked no-store, no-cache or private, and responses which set
+ * cookies, are not cached. Responses to authenticated requests, which have
+ * an Authorization header or a principal, are only cached when they are
+ * explicitly marked public or have an s-maxage, as RFC 9111 section 3.5
+ * requires, since they are otherwise served to other users. Concurrent
+ * misses for the same key are coalesced so that only one request reaches
+ * the handler. HEAD requests are answered from cached GET responses when
+ * there are any and are otherwise passed through.
+ *
+ * Cached values which are not entities are shared between requests and must
+ * not be modified after they are returned from a handler.
+ */
+type Cache struct {
+  sync.Mutex
+  store     Store
+  ttl       time.Duration
+  stale     time.Duration
+  key       func(*rest.Request)(string)
+  inflight  map[string]*call
+  varies    map[string][]string
+}
+
+/**
+ * An in-flight request for a key
+ */
+type call struct {
+  sync.WaitGroup
+  entry *Entry
+}
+
+/**
+ * Create a cache
+ */
+func New(o Options) *Cache {
+  c := &Cache{
+    store: o.Store,
+    ttl: o.TTL,
+    stale: o.StaleWhileRevalidate,
+    key: o.Key,
+    inflight: make(map[string]*call),
+    varies: make(map[string][]string),
+  }
+  if c.store == nil {
+    c.store = NewMemoryStore(DefaultCapacity)
+  }
+  if c.key == nil {
+    c.key = defaultKey
+  }
+  return c
+}
+
+/**
+ * Obtain the cache handling a request, if any
+ */
+func FromRequest(req *rest.Request) *Cache {
+  if req.Attrs == nil {
+    return nil
+  }
+  c, _ := req.Attrs[attrCache].(*Cache)
+  return c
+}
+
+/**
+ * Tag the response to a request so that it can later be purged by tag
+ */
+func Tag(req *rest.Request, tags ...string) {
+  if req.Attrs == nil {
+    req.Attrs = make(rest.Attrs)
+  }
+  var t []string
+  if v, ok := req.Attrs[ATTR_TAGS].([]string); ok {
+    t = append(t, v...) // copy, the existing tags may belong to the route
+  }
+  req.Attrs[ATTR_TAGS] = append(t, tags...)
+}
+
+/**
+ * Purge an entry by its base key. Every variant of the entry is purged.
+ */
+func (c *Cache) Purge(key string) {
+  c.Lock()
+  vary := c.varies[key]
+  delete(c.varies, key)
+  c.Unlock()
+  c.store.Delete(key)
+  if len(vary) > 0 {
+    c.store.PurgeTag(variantTag(key))
+  }
+}
+
+/**
+ * Purge every entry with the specified tag
+ */
+func (c *Cache) PurgeTag(tag string) int {
+  return c.store.PurgeTag(tag)
+}
+
+/**
+ * Obtain the base cache key for a request
+ */
+func (c *Cache) Key(req *rest.Request) string {
+  return c.key(req)
+}
+
+/**
+ * Serve a request
+ */
+func (c *Cache) ServeRequest(rsp http.ResponseWriter, req *rest.Request, pln rest.Pipeline) (interface{}, error) {
+  if req.Method != "GET" && req.Method != "HEAD" {
+    return pln.Next(rsp, req)
+  }
+
+  if req.Attrs == nil {
+    req.Attrs = make(rest.Attrs)
+  }
+  req.Attrs[attrCache] = c
+
+  directives := parseCacheControl(req.Header.Get("Cache-Control"))
+  if _, ok := directives["no-store"]; ok {
+    return pln.Next(rsp, req)
+  }
+
+  base := c.key(req)
+  now := time.Now()
+
+  if _, ok := directives["no-cache"]; !ok {
+    if e, ok := c.lookup(base, req); ok && e.Usable(now) {
+      if e.Fresh(now) {
+        return c.serve(rsp, e, "HIT")
+      }
+      c.revalidate(base, req, pln)
+      return c.serve(rsp, e, "STALE")
+    }
+  }
+
+  if req.Method == "HEAD" {
+    return pln.Next(rsp, req) // HEAD responses have no content to store
+  }
+  return c.fetch(base, rsp, req, pln)
+}
+
+/**
+ * Look up the entry for a request
+ */
+func (c *Cache) lookup(base string, req *rest.Request) (*Entry, bool) {
+  c.Lock()
+  vary := c.varies[base]
+  c.Unlock()
+  return c.store.Get(variantKey(base, req, vary))
+}
+
+/**
+ * Serve an entry
+ */
+func (c *Cache) serve(rsp http.ResponseWriter, e *Entry, status string) (interface{}, error) {
+  h := rsp.Header()
+  for k, v := range e.Header {
+    h[k] = v
+  }
+  h.Set("Age", strconv.Itoa(int(time.Since(e.Created) / time.Second)))
+  h.Set("X-Cache", status)
+  if e.Entity != nil {
+    return rest.NewBytesEntity(e.ContentType, e.Entity), nil
+  }else{
+    return e.Value, nil
+  }
+}
+
+/**
+ * Fetch a response from upstream, coalescing concurrent requests for the
+ * same key
+ */
+func (c *Cache) fetch(base string, rsp http.ResponseWriter, req *rest.Request, pln rest.Pipeline) (interface{}, error) {
+  c.Lock()
+  if f, ok := c.inflight[base]; ok {
+    c.Unlock()
+    f.Wait()
+    if e := f.entry; e != nil && e.Key == c.variant(base, req) {
+      return c.serve(rsp, e, "HIT")
+    }
+    return pln.Next(rsp, req) // the response was not cacheable or is a different variant
+  }
+  f := &call{}
+  f.Add(1)
+  c.inflight[base] = f
+  c.Unlock()
+
+  defer func() {
+    c.Lock()
+    delete(c.inflight, base)
+    c.Unlock()
+    f.Done()
+  }()
+
+  rsp.Header().Set("X-Cache", "MISS")
+  res, err, e := c.exec(base, rsp, req, pln)
+  f.entry = e
+  return res, err
+}
+
+/**
+ * Revalidate a stale entry in the background. If the entry is already
+ * being fetched nothing is done.
+ */
+func (c *Cache) revalidate(base string, req *rest.Request, pln rest.Pipeline) {
+  c.Lock()
+  if _, ok := c.inflight[base]; ok {
+    c.Unlock()
+    return
+  }
+  f := &call{}
+  f.Add(1)
+  c.inflight[base] = f
+  c.Unlock()
+
+  breq := req.Clone(context.Background())
+  breq.Method = "GET" // only GET responses are stored
+  breq.Body = http.NoBody
+
+  go func() {
+    defer func() {
+      c.Lock()
+      delete(c.inflight, base)
+      c.Unlock()
+      f.Done()
+    }()
+    _, _, f.entry = c.exec(base, httptest.NewRecorder(), breq, pln)
+  }()
+}
+
+/**
+ * Execute the pipeline and store the result if it can be cached
+ */
+func (c *Cache) exec(base string, rsp http.ResponseWriter, req *rest.Request, pln rest.Pipeline) (interface{}, error, *Entry) {
+  before := snapshot(rsp.Header())
+
+  res, err := pln.Next(rsp, req)
+  if err != nil || req.Finalized() {
+    return res, err, nil
+  }
+
+  header := changed(before, rsp.Header())
+  header.Del("X-Cache")
+  if header.Get("Set-Cookie") != "" {
+    return res, nil, nil
+  }
+
+  directives := parseCacheControl(header.Get("Cache-Control"))
+  for _, e := range []string{"no-store", "no-cache", "private"} {
+    if _, ok := directives[e]; ok {
+      return res, nil, nil
+    }
+  }
+
+  if authenticated(req) {
+    _, public := directives["public"]
+    _, shared := directives["s-maxage"]
+    if !public && !shared {
+      return res, nil, nil
+    }
+  }
+
+  var vary []string
+  for _, e := range header.Values("Vary") {
+    for _, v := range strings.Split(e, ",") {
+      if v = strings.TrimSpace(v); v == "*" {
+        return res, nil, nil
+      }else if v != "" {
+        vary = append(vary, http.CanonicalHeaderKey(v))
+      }
+    }
+  }
+
+  ttl := c.ttl
+  if v, ok := req.Attrs[ATTR_TTL].(time.Duration); ok {
+    ttl = v
+  }
+  if v, ok := directives["s-maxage"]; ok {
+    ttl = seconds(v)
+  }else if v, ok := directives["max-age"]; ok {
+    ttl = seconds(v)
+  }
+  if ttl <= 0 {
+    return res, nil, nil
+  }
+
+  stale := c.stale
+  if v, ok := req.Attrs[ATTR_STALE].(time.Duration); ok {
+    stale = v
+  }
+  if v, ok := directives["stale-while-revalidate"]; ok {
+    stale = seconds(v)
+  }
+
+  now := time.Now()
+  e := &Entry{
+    Key: variantKey(base, req, vary),
+    Header: header,
+    Created: now,
+    Expires: now.Add(ttl),
+    Stale: now.Add(ttl + stale),
+  }
+  if v, ok := req.Attrs[ATTR_TAGS].([]string); ok {
+    e.Tags = append(e.Tags, v...)
+  }
+  if len(vary) > 0 {
+    e.Tags = append(e.Tags, variantTag(base))
+  }
+
+  if v, ok := res.(rest.Entity); ok {
+    data, err := ioutil.ReadAll(v)
+    if err != nil {
+      return nil, err, nil
+    }
+    e.Entity = data
+    e.ContentType = v.ContentType()
+    res = rest.NewBytesEntity(e.ContentType, data)
+  }else{
+    e.Value = res
+  }
+
+  c.Lock()
+  c.varies[base] = vary
+  c.Unlock()
+  c.store.Set(e)
+
+  return res, nil, e
+}
+
+/**
+ * Produce the variant key for a request given the headers its response
+ * varies on
+ */
+func (c *Cache) variant(base string, req *rest.Request) string {
+  c.Lock()
+  vary := c.varies[base]
+  c.Unlock()
+  return variantKey(base, req, vary)
+}
+
+/**
+ * Produce the variant key for a request
+ */
+func variantKey(base string, req *rest.Request, vary []string) string {
+  if len(vary) == 0 {
+    return base
+  }
+  k := base
+  for _, e := range vary {
+    k += "\n"+ e +": "+ strings.Join(req.Header.Values(e), ", ")
+  }
+  return k
+}
+
+/**
+ * The tag applied to every variant of a base key
+ */
+func variantTag(base string) string {
+  return "cache.variant:"+ base
+}
+
+/**
+ * Determine if a request is authenticated
+ */
+func authenticated(req *rest.Request) bool {
+  return req.Header.Get("Authorization") != "" || req.Principal != nil
+}
+
+/**
+ * The default cache key
+ */
+func defaultKey(req *rest.Request) string {
+  return req.Host + req.URL.RequestURI()
+}
+
+/**
+ * Parse Cache-Control directives
+ */
+func parseCacheControl(h string) map[string]string {
+  d := make(map[string]string)
+  for _, e := range strings.Split(h, ",") {
+    e = strings.TrimSpace(e)
+    if e == "" {
+      continue
+    }
+    if x := strings.Index(e, "="); x > 0 {
+      d[strings.ToLower(e[:x])] = strings.Trim(e[x+1:], `"`)
+    }else{
+      d[strings.ToLower(e)] = ""
+    }
+  }
+  return d
+}
+
+/**
+ * Convert a directive value in seconds to a duration
+ */
+func seconds(v string) time.Duration {
+  n, err := strconv.Atoi(v)
+  if err != nil || n < 0 {
+    return 0
+  }
+  return time.Duration(n) * time.Second
+}
+
+/**
+ * Copy headers
+ */
+func snapshot(h http.Header) http.Header {
+  c := make(http.Header)
+  for k, v := range h {
+    c[k] = append([]string(nil), v...)
+  }
+  return c
+}
+
+/**
+ * Obtain the headers which changed between two header sets
+ */
+func changed(before, after http.Header) http.Header {
+  c := make(http.Header)
+  for k, v := range after {
+    if b, ok := before[k]; !ok || strings.Join(b, "\n") != strings.Join(v, "\n") {
+      c[k] = append([]string(nil), v...)
+    }
+  }
+  return c
+}
@@ -0,0 +1,154 @@
+package cache
+
+import (
+  "sync"
+  "time"
+  "net/http"
+  "container/list"
+)
+
+/**
+ * A cached response
+ */
+type Entry struct {
+  Key         string
+  Value       interface{}   // the handler result, for results which are not entities
+  Entity      []byte        // the rendered entity data, for results which are entities
+  ContentType string        // the content type of a rendered entity
+  Header      http.Header   // headers set by the handler
+  Tags        []string
+  Created     time.Time
+  Expires     time.Time     // the entry is fresh until this time
+  Stale       time.Time     // the entry may be served stale while it is revalidated until this time
+}
+
+/**
+ * Determine if the entry is fresh
+ */
+func (e *Entry) Fresh(now time.Time) bool {
+  return now.Before(e.Expires)
+}
+
+/**
+ * Determine if the entry may be served while it is revalidated
+ */
+func (e *Entry) Usable(now time.Time) bool {
+  return now.Before(e.Expires) || now.Before(e.Stale)
+}
+
+/**
+ * A cache store
+ */
+type Store interface {
+  Get(key string)(*Entry, bool)
+  Set(e *Entry)
+  Delete(key string)
+  PurgeTag(tag string)(int)
+}
+
+/**
+ * An in-memory, least-recently-used store
+ */
+type MemoryStore struct {
+  sync.Mutex
+  capacity  int
+  lru       *list.List
+  entries   map[string]*list.Element
+  tags      map[string]map[string]struct{}
+}
+
+/**
+ * Create a memory store that holds at most the specified number of entries
+ */
+func NewMemoryStore(n int) *MemoryStore {
+  if n < 1 {
+    n = 1
+  }
+  return &MemoryStore{
+    capacity: n,
+    lru: list.New(),
+    entries: make(map[string]*list.Element),
+    tags: make(map[string]map[string]struct{}),
+  }
+}
+
+/**
+ * Obtain an entry
+ */
+func (s *MemoryStore) Get(key string) (*Entry, bool) {
+  s.Lock()
+  defer s.Unlock()
+  if e, ok := s.entries[key]; ok {
+    s.lru.MoveToFront(e)
+    return e.Value.(*Entry), true
+  }
+  return nil, false
+}
+
+/**
+ * Store an entry, evicting the least recently used entry if the store is full
+ */
+func (s *MemoryStore) Set(v *Entry) {
+  s.Lock()
+  defer s.Unlock()
+  if e, ok := s.entries[v.Key]; ok {
+    s.remove(e)
+  }
+  s.entries[v.Key] = s.lru.PushFront(v)
+  for _, t := range v.Tags {
+    k, ok := s.tags[t]
+    if !ok {
+      k = make(map[string]struct{})
+      s.tags[t] = k
+    }
+    k[v.Key] = struct{}{}
+  }
+  for s.lru.Len() > s.capacity {
+    s.remove(s.lru.Back())
+  }
+}
+
+/**
+ * Delete an entry
+ */
+func (s *MemoryStore) Delete(key string) {
+  s.Lock()
+  defer s.Unlock()
+  if e, ok := s.entries[key]; ok {
+    s.remove(e)
+  }
+}
+
+/**
+ * Delete every entry with the specified tag
+ */
+func (s *MemoryStore) PurgeTag(tag string) int {
+  s.Lock()
+  defer s.Unlock()
+  n := 0
+  for k, _ := range s.tags[tag] {
+    if e, ok := s.entries[k]; ok {
+      s.remove(e)
+      n++
+    }
+  }
+  delete(s.tags, tag)
+  return n
+}
+
+/**
+ * Remove an element; the store must be locked
+ */
+func (s *MemoryStore) remove(e *list.Element) {
+  v := e.Value.(*Entry)
+  s.lru.Remove(e)
+  delete(s.entries, v.Key)
+  for _, t := range v.Tags {
+    if k, ok := s.tags[t]; ok {
+      delete(k, v.Key)
+      if len(k) == 0 {
+        delete(s.tags, t)
+      }
+    }
+  }
+}
@@ -0,0 +1,392 @@
+package contract
+
+import (
+  "bytes"
+  "sort"
+  "strconv"
+  "strings"
+  "net/url"
+  "io/ioutil"
+  "net/http"
+  "encoding/json"
+)
+
+import (
+  "github.com/bww/go-rest"
+  "github.com/bww/go-alert"
+)
+
+/**
+ * Contract options
+ */
+type Options struct {
+  BasePath          string  // a prefix removed from request paths before they are matched to the document's paths
+  ReportOnly        bool    // log request violations as contract violations instead of rejecting requests
+  ValidateResponses bool    // validate handler results against response schemas and log violations
+  Strict            bool    // reject requests that match no operation and fail responses which violate the contract
+}
+
+/**
+ * A contract handler validates requests, and optionally responses, against
+ * an OpenAPI document. Each request is matched to an operation in the
+ * document and its path, query and header parameters and JSON entity are
+ * validated before the pipeline continues. Violations produce a 400 whose
+ * Detail lists every violation.
+ *
+ * Only local component references are resolved and only JSON entities are
+ * validated; requests which match no operation are passed through unless
+ * the contract is strict. The handler should be used in a context pipeline
+ * so that the entities it reads are limited and decoded.
+ */
+type Contract struct {
+  doc     *rest.OpenAPI
+  opts    Options
+  routes  []*route
+}
+
+/**
+ * A path in the document
+ */
+type route struct {
+  path      string
+  segments  []string
+  literals  int
+  item      *rest.PathItem
+}
+
+/**
+ * Create a contract handler for a document
+ */
+func New(doc *rest.OpenAPI, opts Options) *Contract {
+  c := &Contract{doc:doc, opts:opts}
+  for p, e := range doc.Paths {
+    r := &route{path:p, segments:splitPath(p), item:e}
+    for _, s := range r.segments {
+      if !isVariable(s) {
+        r.literals++
+      }
+    }
+    c.routes = append(c.routes, r)
+  }
+  sort.Slice(c.routes, func(i, j int) bool {
+    a, b := c.routes[i], c.routes[j]
+    if a.literals != b.literals {
+      return a.literals > b.literals // prefer the most specific path
+    }
+    return a.path < b.path
+  })
+  return c
+}
+
+/**
+ * Create a contract handler for a document on disk
+ */
+func Load(path string, opts Options) (*Contract, error) {
+  doc, err := rest.LoadOpenAPI(path)
+  if err != nil {
+    return nil, err
+  }
+  return New(doc, opts), nil
+}
+
+/**
+ * Serve a request
+ */
+func (c *Contract) ServeRequest(rsp http.ResponseWriter, req *rest.Request, pln rest.Pipeline) (interface{}, error) {
+  path := req.URL.Path
+  if c.opts.BasePath != "" {
+    if !strings.HasPrefix(path, c.opts.BasePath) {
+      return pln.Next(rsp, req)
+    }
+    path = path[len(c.opts.BasePath):]
+  }
+
+  r, vars := c.match(path)
+  if r == nil {
+    if c.opts.Strict {
+      return nil, rest.NewErrorf(http.StatusNotFound, "No operation is described for: %v", req.URL.Path)
+    }
+    return pln.Next(rsp, req)
+  }
+
+  ops := r.item.Operations()
+  op, ok := ops[req.Method]
+  if !ok && req.Method == "HEAD" {
+    op, ok = ops["GET"]
+  }
+  if !ok {
+    if c.opts.Strict {
+      allow := make([]string, 0, len(ops))
+      for k, _ := range ops {
+        allow = append(allow, k)
+      }
+      sort.Strings(allow)
+      return nil, rest.NewErrorf(http.StatusMethodNotAllowed, "Method is not described for %v: %v", r.path, req.Method).SetHeaders(map[string]string{"Allow": strings.Join(allow, ", ")})
+    }
+    return pln.Next(rsp, req)
+  }
+
+  if err := c.validateRequest(req, r, op, vars); err != nil {
+    if !c.opts.ReportOnly {
+      return nil, err
+    }
+    alt.Errorf("contract: [%v] Request violates contract for %v %v: %v", req.Id, req.Method, r.path, err)
+  }
+
+  res, err := pln.Next(rsp, req)
+  if err == nil && (c.opts.ValidateResponses || c.opts.Strict) && !req.Finalized() {
+    if verr := c.validateResponse(op, res); verr != nil {
+      alt.Errorf("contract: [%v] Response violates contract for %v %v: %v", req.Id, req.Method, r.path, verr)
+      if c.opts.Strict {
+        return nil, rest.NewErrorf(http.StatusInternalServerError, "Response violates contract for %v %v", req.Method, r.path).SetDetail(verr.(rest.FieldErrors))
+      }
+    }
+  }
+
+  return res, err
+}
+
+/**
+ * Match a request path to a path in the document
+ */
+func (c *Contract) match(path string) (*route, map[string]string) {
+  segs := splitPath(path)
+  outer:
+  for _, r := range c.routes {
+    if len(r.segments) != len(segs) {
+      continue
+    }
+    vars := make(map[string]string)
+    for i, s := range r.segments {
+      if isVariable(s) {
+        v, err := unescape(segs[i])
+        if err != nil || v == "" {
+          continue outer
+        }
+        vars[s[1:len(s)-1]] = v
+      }else if s != segs[i] {
+        continue outer
+      }
+    }
+    return r, vars
+  }
+  return nil, nil
+}
+
+/**
+ * Validate a request
+ */
+func (c *Contract) validateRequest(req *rest.Request, r *route, op *rest.Operation, vars map[string]string) error {
+  var errs rest.FieldErrors
+
+  query := req.URL.Query()
+  for _, p := range c.doc.OperationParameters(r.item, op) {
+    var vals []string
+    switch p.In {
+      case "path":
+        if v, ok := vars[p.Name]; ok {
+          vals = []string{v}
+        }
+      case "query":
+        vals = query[p.Name]
+      case "header":
+        vals = req.Header.Values(p.Name)
+      case "cookie":
+        if k, err := req.Cookie(p.Name); err == nil {
+          vals = []string{k.Value}
+        }
+      default:
+        continue
+    }
+    if len(vals) == 0 {
+      if p.Required {
+        errs = append(errs, rest.FieldError{Field:p.Name, Source:p.In, Message:"is required"})
+      }
+      continue
+    }
+    if p.Schema != nil {
+      s := c.doc.ResolveSchema(p.Schema)
+      errs = append(errs, validateValue(c.doc, p.In, p.Name, s, coerce(c.doc, s, vals))...)
+    }
+  }
+
+  if b := c.doc.ResolveRequestBody(op.RequestBody); b != nil {
+    berrs, err := c.validateEntity(req, b)
+    if err != nil {
+      return err
+    }
+    errs = append(errs, berrs...)
+  }
+
+  if len(errs) > 0 {
+    return rest.NewErrorf(http.StatusBadRequest, "Request violates contract: %v", errs).SetDetail(errs)
+  }
+  return nil
+}
+
+/**
+ * Validate a request entity
+ */
+func (c *Contract) validateEntity(req *rest.Request, b *rest.RequestBody) (rest.FieldErrors, error) {
+  var data []byte
+  if req.Body != nil && req.Body != http.NoBody {
+    var err error
+    data, err = ioutil.ReadAll(req.Body)
+    if rerr, ok := err.(*rest.Error); ok {
+      return nil, rerr
+    }else if err != nil {
+      return nil, rest.NewErrorf(http.StatusBadRequest, "Could not read request entity: %v", err)
+    }
+    req.Body = ioutil.NopCloser(bytes.NewReader(data)) // leave the entity for the handler
+  }
+
+  if len(bytes.TrimSpace(data)) == 0 {
+    if b.Required {
+      return rest.FieldErrors{{Source:"body", Message:"an entity is required"}}, nil
+    }
+    return nil, nil
+  }
+
+  ctype := strings.ToLower(req.Header.Get("Content-Type"))
+  if x := strings.Index(ctype, ";"); x >= 0 {
+    ctype = strings.TrimSpace(ctype[:x])
+  }
+  mt := mediaType(b.Content, ctype)
+  if mt == nil {
+    return nil, rest.NewErrorf(http.StatusUnsupportedMediaType, "Unsupported request entity content type: %v", ctype)
+  }
+  if mt.Schema == nil || !isJSON(ctype) {
+    return nil, nil
+  }
+
+  var v interface{}
+  if err := json.Unmarshal(data, &v); err != nil {
+    return nil, rest.NewErrorf(http.StatusBadRequest, "Could not unmarshal request entity: %v", err)
+  }
+  return validateValue(c.doc, "body", "", mt.Schema, v), nil
+}
+
+/**
+ * Validate a handler result against the success response of an operation
+ */
+func (c *Contract) validateResponse(op *rest.Operation, res interface{}) error {
+  r := c.doc.ResponseForStatus(op.Responses, http.StatusOK)
+  if r == nil {
+    return rest.FieldErrors{{Source:"response", Message:"no response is described for status 200"}}
+  }
+  if res == nil {
+    return nil // nothing to check
+  }
+
+  var data []byte
+  switch e := res.(type) {
+    case rest.Entity:
+      return nil // streaming entities are not validated
+    case json.RawMessage:
+      data = e
+    default:
+      var err error
+      data, err = json.Marshal(res)
+      if err != nil {
+        return rest.FieldErrors{{Source:"response", Message:err.Error()}}
+      }
+  }
+
+  mt := mediaType(r.Content, rest.CONTENT_TYPE_JSON)
+  if mt == nil || mt.Schema == nil {
+    return nil
+  }
+  var v interface{}
+  if err := json.Unmarshal(data, &v); err != nil {
+    return rest.FieldErrors{{Source:"response", Message:err.Error()}}
+  }
+  if errs := validateValue(c.doc, "response", "", mt.Schema, v); len(errs) > 0 {
+    return errs
+  }
+  return nil
+}
+
+/**
+ * Coerce parameter values to the type their schema describes; values that
+ * cannot be converted are left as strings so that validation reports them
+ */
+func coerce(doc *rest.OpenAPI, s *rest.Schema, vals []string) interface{} {
+  if s == nil {
+    return vals[0]
+  }
+  if s.Type.Has("array") {
+    if len(vals) == 1 {
+      vals = strings.Split(vals[0], ",")
+    }
+    items := doc.ResolveSchema(s.Items)
+    l := make([]interface{}, len(vals))
+    for i, e := range vals {
+      l[i] = coerce(doc, items, []string{e})
+    }
+    return l
+  }
+  v := vals[0]
+  switch {
+    case s.Type.Has("integer"), s.Type.Has("number"):
+      if n, err := strconv.ParseFloat(v, 64); err == nil {
+        return n
+      }
+    case s.Type.Has("boolean"):
+      if b, err := strconv.ParseBool(v); err == nil {
+        return b
+      }
+  }
+  return v
+}
+
+/**
+ * Find the media type matching a content type, allowing for wildcards
+ */
+func mediaType(content map[string]*rest.MediaType, ctype string) *rest.MediaType {
+  if len(content) == 0 {
+    return &rest.MediaType{}
+  }
+  if m, ok := content[ctype]; ok {
+    return m
+  }
+  if x := strings.Index(ctype, "/"); x > 0 {
+    if m, ok := content[ctype[:x] +"/*"]; ok {
+      return m
+    }
+  }
+  if m, ok := content["*/*"]; ok {
+    return m
+  }
+  if ctype == "" {
+    return content[rest.CONTENT_TYPE_JSON]
+  }
+  return nil
+}
+
+/**
+ * Determine if a content type is JSON; an absent content type is assumed to be
+ */
+func isJSON(ctype string) bool {
+  return ctype == "" || ctype == rest.CONTENT_TYPE_JSON || strings.HasSuffix(ctype, "+json")
+}
+
+/**
+ * Split a path into segments
+ */
+func splitPath(p string) []string {
+  return strings.Split(strings.Trim(p, "/"), "/")
+}
+
+/**
+ * Determine if a path segment is a variable
+ */
+func isVariable(s string) bool {
+  return len(s) > 2 && s[0] == '{' && s[len(s)-1] == '}'
+}
+
+/**
+ * Unescape a path segment
+ */
+func unescape(s string) (string, error) {
+  return url.PathUnescape(s)
+}
@@ -0,0 +1,288 @@
+package contract
+
+import (
+  "fmt"
+  "sync"
+  "time"
+  "math"
+  "regexp"
+  "strings"
+  "reflect"
+  "net/url"
+  "net/mail"
+  "unicode/utf8"
+)
+
+import (
+  "github.com/bww/go-rest"
+)
+
+/**
+ * Compiled schema patterns
+ */
+var patterns sync.Map
+
+/**
+ * Validates JSON values against schemas in a document
+ */
+type validator struct {
+  doc     *rest.OpenAPI
+  source  string
+  errs    rest.FieldErrors
+}
+
+/**
+ * Validate a JSON value, as produced by unmarshaling into an interface{},
+ * against a schema
+ */
+func validateValue(doc *rest.OpenAPI, source, field string, s *rest.Schema, v interface{}) rest.FieldErrors {
+  c := &validator{doc:doc, source:source}
+  c.validate(s, field, v)
+  return c.errs
+}
+
+/**
+ * Note a violation
+ */
+func (c *validator) fail(field, f string, a ...interface{}) {
+  c.errs = append(c.errs, rest.FieldError{Field:field, Source:c.source, Message:fmt.Sprintf(f, a...)})
+}
+
+/**
+ * Validate a value
+ */
+func (c *validator) validate(s *rest.Schema, field string, v interface{}) {
+  if s == nil {
+    return
+  }
+  if s.Ref != "" {
+    r := c.doc.ResolveSchema(s)
+    if r == nil {
+      c.fail(field, "schema reference cannot be resolved: %s", s.Ref)
+      return
+    }
+    s = r
+  }
+
+  for _, e := range s.AllOf {
+    c.validate(e, field, v)
+  }
+  if len(s.AnyOf) > 0 && c.matches(s.AnyOf, v) == 0 {
+    c.fail(field, "does not match any permitted schema")
+  }
+  if len(s.OneOf) > 0 {
+    if n := c.matches(s.OneOf, v); n != 1 {
+      c.fail(field, "must match exactly one schema, matches %d", n)
+    }
+  }
+  if s.Not != nil && c.matches([]*rest.Schema{s.Not}, v) > 0 {
+    c.fail(field, "matches a schema it must not")
+  }
+
+  if v == nil {
+    if len(s.Type) > 0 && !s.Type.Has("null") && !s.Nullable {
+      c.fail(field, "must not be null")
+    }
+    return
+  }
+
+  if len(s.Type) > 0 && !typeMatches(s.Type, v) {
+    c.fail(field, "must be of type %s", strings.Join(s.Type, " or "))
+    return
+  }
+
+  if len(s.Enum) > 0 {
+    found := false
+    for _, e := range s.Enum {
+      if reflect.DeepEqual(e, v) {
+        found = true
+        break
+      }
+    }
+    if !found {
+      c.fail(field, "must be one of: %v", s.Enum)
+    }
+  }
+
+  switch x := v.(type) {
+    case string:
+      c.validateString(s, field, x)
+    case float64:
+      c.validateNumber(s, field, x)
+    case []interface{}:
+      c.validateArray(s, field, x)
+    case map[string]interface{}:
+      c.validateObject(s, field, x)
+  }
+}
+
+/**
+ * Count the schemas a value matches
+ */
+func (c *validator) matches(l []*rest.Schema, v interface{}) int {
+  n := 0
+  for _, e := range l {
+    if len(validateValue(c.doc, c.source, "", e, v)) == 0 {
+      n++
+    }
+  }
+  return n
+}
+
+/**
+ * Determine if a value is one of a set of types
+ */
+func typeMatches(t rest.SchemaType, v interface{}) bool {
+  switch x := v.(type) {
+    case bool:
+      return t.Has("boolean")
+    case float64:
+      return t.Has("number") || (t.Has("integer") && x == math.Trunc(x))
+    case string:
+      return t.Has("string")
+    case []interface{}:
+      return t.Has("array")
+    case map[string]interface{}:
+      return t.Has("object")
+    default:
+      return false
+  }
+}
+
+/**
+ * Validate a string
+ */
+func (c *validator) validateString(s *rest.Schema, field, v string) {
+  n := utf8.RuneCountInString(v)
+  if s.MinLength != nil && n < *s.MinLength {
+    c.fail(field, "must have a length of at least %d", *s.MinLength)
+  }
+  if s.MaxLength != nil && n > *s.MaxLength {
+    c.fail(field, "must have a length of at most %d", *s.MaxLength)
+  }
+  if s.Pattern != "" {
+    var r *regexp.Regexp
+    if e, ok := patterns.Load(s.Pattern); ok {
+      r = e.(*regexp.Regexp)
+    }else if e, err := regexp.Compile(s.Pattern); err == nil {
+      r = e
+      patterns.Store(s.Pattern, r)
+    }
+    if r != nil && !r.MatchString(v) {
+      c.fail(field, "must match %s", s.Pattern)
+    }
+  }
+  if s.Format != "" && !formatMatches(s.Format, v) {
+    c.fail(field, "must be a valid %s", s.Format)
+  }
+}
+
+/**
+ * Determine if a string conforms to a format. Unknown formats are accepted.
+ */
+func formatMatches(f, v string) bool {
+  switch f {
+    case "date-time":
+      _, err := time.Parse(time.RFC3339Nano, v)
+      return err == nil
+    case "date":
+      _, err := time.Parse("2006-01-02", v)
+      return err == nil
+    case "email":
+      a, err := mail.ParseAddress(v)
+      return err == nil && a.Address == v
+    case "uri", "url":
+      u, err := url.Parse(v)
+      return err == nil && u.Scheme != ""
+    case "uuid":
+      _, err := rest.ParseUUID(v)
+      return err == nil && len(v) == 36
+    default:
+      return true
+  }
+}
+
+/**
+ * Validate a number
+ */
+func (c *validator) validateNumber(s *rest.Schema, field string, v float64) {
+  if s.Minimum != nil {
+    if e, _ := s.ExclusiveMinimum.(bool); e && v <= *s.Minimum {
+      c.fail(field, "must be greater than %v", *s.Minimum)
+    }else if v < *s.Minimum {
+      c.fail(field, "must be at least %v", *s.Minimum)
+    }
+  }
+  if s.Maximum != nil {
+    if e, _ := s.ExclusiveMaximum.(bool); e && v >= *s.Maximum {
+      c.fail(field, "must be less than %v", *s.Maximum)
+    }else if v > *s.Maximum {
+      c.fail(field, "must be at most %v", *s.Maximum)
+    }
+  }
+  if e, ok := s.ExclusiveMinimum.(float64); ok && v <= e {
+    c.fail(field, "must be greater than %v", e)
+  }
+  if e, ok := s.ExclusiveMaximum.(float64); ok && v >= e {
+    c.fail(field, "must be less than %v", e)
+  }
+}
+
+/**
+ * Validate an array
+ */
+func (c *validator) validateArray(s *rest.Schema, field string, v []interface{}) {
+  if s.MinItems != nil && len(v) < *s.MinItems {
+    c.fail(field, "must have at least %d items", *s.MinItems)
+  }
+  if s.MaxItems != nil && len(v) > *s.MaxItems {
+    c.fail(field, "must have at most %d items", *s.MaxItems)
+  }
+  if s.UniqueItems {
+    for i := 0; i < len(v); i++ {
+      for j := i + 1; j < len(v); j++ {
+        if reflect.DeepEqual(v[i], v[j]) {
+          c.fail(field, "must have unique items")
+          i = len(v)
+          break
+        }
+      }
+    }
+  }
+  if s.Items != nil {
+    for i, e := range v {
+      c.validate(s.Items, fmt.Sprintf("%s[%d]", field, i), e)
+    }
+  }
+}
+
+/**
+ * Validate an object
+ */
+func (c *validator) validateObject(s *rest.Schema, field string, v map[string]interface{}) {
+  for _, e := range s.Required {
+    if _, ok := v[e]; !ok {
+      c.fail(join(field, e), "is required")
+    }
+  }
+  for k, e := range v {
+    if p, ok := s.Properties[k]; ok {
+      c.validate(p, join(field, k), e)
+    }else if s.NoAdditional {
+      c.fail(join(field, k), "is not permitted")
+    }else if s.AdditionalProperties != nil {
+      c.validate(s.AdditionalProperties, join(field, k), e)
+    }
+  }
+}
+
+/**
+ * Join a field path
+ */
+func join(p, n string) string {
+  if p == "" {
+    return n
+  }else{
+    return p +"."+ n
+  }
+}
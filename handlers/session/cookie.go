@@ -0,0 +1,152 @@
+package session
+
+import (
+  "fmt"
+  "strings"
+  "crypto/aes"
+  "crypto/hmac"
+  "crypto/rand"
+  "crypto/cipher"
+  "crypto/sha256"
+  "encoding/json"
+  "encoding/base64"
+)
+
+/**
+ * The largest cookie value produced; browsers are not required to store
+ * larger cookies
+ */
+const maxCookieSize = 4000
+
+/**
+ * A cookie session key. Cookies are signed with HMAC-SHA256 or, if the key
+ * has an encryption key, encrypted and authenticated with AES-GCM, in which
+ * case cookies which are only signed are not accepted with the key.
+ */
+type Key struct {
+  Sign    []byte // the signing key, of at least 32 bytes, if cookies are not encrypted
+  Encrypt []byte // the AES encryption key, of 16, 24 or 32 bytes, if cookies are encrypted
+}
+
+/**
+ * Check a key
+ */
+func (k Key) validate() error {
+  if k.Encrypt == nil && len(k.Sign) < 32 {
+    return fmt.Errorf("Signing key must be at least 32 bytes")
+  }
+  if k.Encrypt != nil {
+    if _, err := aes.NewCipher(k.Encrypt); err != nil {
+      return fmt.Errorf("Invalid encryption key: %v", err)
+    }
+  }
+  return nil
+}
+
+/**
+ * Encode session data as a cookie value with a key. The cookie name is
+ * authenticated with the data so that values cannot be moved between
+ * cookies.
+ */
+func encodeCookie(k Key, name string, d *Data) (string, error) {
+  data, err := json.Marshal(d)
+  if err != nil {
+    return "", err
+  }
+  var v string
+  if k.Encrypt != nil {
+    aead, err := newAEAD(k.Encrypt)
+    if err != nil {
+      return "", err
+    }
+    nonce := make([]byte, aead.NonceSize())
+    if _, err := rand.Read(nonce); err != nil {
+      return "", err
+    }
+    v = "e."+ base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, data, []byte(name)))
+  }else{
+    p := base64.RawURLEncoding.EncodeToString(data)
+    v = "s."+ p +"."+ base64.RawURLEncoding.EncodeToString(mac(k.Sign, name, p))
+  }
+  if len(v) > maxCookieSize {
+    return "", fmt.Errorf("Session is too large to store in a cookie: %d bytes", len(v))
+  }
+  return v, nil
+}
+
+/**
+ * Decode a cookie value with any of a set of keys; the result is nil if the
+ * value was not produced with any of them. Signed values are only accepted
+ * with keys which sign, so that a deployment which encrypts its cookies
+ * cannot be made to accept plaintext ones.
+ */
+func decodeCookie(keys []Key, name, v string) *Data {
+  var data []byte
+  switch {
+    case strings.HasPrefix(v, "e."):
+      b, err := base64.RawURLEncoding.DecodeString(v[2:])
+      if err != nil {
+        return nil
+      }
+      for _, k := range keys {
+        if k.Encrypt == nil {
+          continue
+        }
+        aead, err := newAEAD(k.Encrypt)
+        if err != nil || len(b) < aead.NonceSize() {
+          continue
+        }
+        if p, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], []byte(name)); err == nil {
+          data = p
+          break
+        }
+      }
+    case strings.HasPrefix(v, "s."):
+      x := strings.LastIndexByte(v, '.')
+      if x < 2 {
+        return nil
+      }
+      p := v[2:x]
+      sig, err := base64.RawURLEncoding.DecodeString(v[x+1:])
+      if err != nil {
+        return nil
+      }
+      for _, k := range keys {
+        if k.Encrypt != nil || len(k.Sign) < 32 {
+          continue
+        }
+        if hmac.Equal(sig, mac(k.Sign, name, p)) {
+          data, _ = base64.RawURLEncoding.DecodeString(p)
+          break
+        }
+      }
+  }
+  if data == nil {
+    return nil
+  }
+  d := &Data{}
+  if err := json.Unmarshal(data, d); err != nil {
+    return nil
+  }
+  return d
+}
+
+/**
+ * Produce an AEAD for an encryption key
+ */
+func newAEAD(key []byte) (cipher.AEAD, error) {
+  b, err := aes.NewCipher(key)
+  if err != nil {
+    return nil, err
+  }
+  return cipher.NewGCM(b)
+}
+
+/**
+ * Sign a cookie payload
+ */
+func mac(key []byte, name, payload string) []byte {
+  m := hmac.New(sha256.New, key)
+  m.Write([]byte(name +"|"+ payload))
+  return m.Sum(nil)
+}
@@ -0,0 +1,484 @@
+package session
+
+import (
+  "fmt"
+  "sync"
+  "time"
+  "net/http"
+  "crypto/rand"
+  "encoding/base64"
+)
+
+import (
+  "github.com/bww/go-rest"
+  "github.com/bww/go-rest/handlers/auth"
+  "github.com/bww/go-alert"
+)
+
+/**
+ * Session defaults
+ */
+const (
+  DefaultName         = "session"
+  DefaultIdleTimeout  = 30 * time.Minute
+  DefaultMaxAge       = 24 * time.Hour
+)
+
+/**
+ * Sessions which are otherwise unmodified are saved, to extend their idle
+ * timeout, at most this often
+ */
+const touchInterval = time.Minute
+
+/**
+ * The request attribute under which the session is available
+ */
+const attrSession = "session.session"
+
+/**
+ * Session options
+ */
+type Options struct {
+  Name        string        // the cookie name; defaults to DefaultName
+  Store       Store         // if set, sessions are stored server-side and the cookie holds only their identifier
+  Keys        []Key         // keys for cookie sessions, newest first; sessions are written with the first and read with any of them, so keys can be rotated
+  Revoker     Revoker       // if set, the cookies of cookie sessions which are regenerated or destroyed are revoked
+  IdleTimeout time.Duration // sessions expire when unused for this period; defaults to DefaultIdleTimeout
+  MaxAge      time.Duration // sessions expire this long after they are created, regardless of use; defaults to DefaultMaxAge
+  Path        string        // the cookie path; defaults to /
+  Domain      string        // the cookie domain, if any
+  Insecure    bool          // if set, the cookie is sent over plain HTTP; this should only be used in development
+  SameSite    http.SameSite // the cookie SameSite mode; defaults to Lax
+}
+
+/**
+ * A session manager. Sessions are either stored entirely in a signed and,
+ * optionally, encrypted cookie, or stored server-side and identified by a
+ * random identifier in the cookie. Cookies are HttpOnly, Secure and
+ * SameSite=Lax by default.
+ *
+ * The manager is a handler which loads the session of each request, which
+ * handlers obtain with FromRequest, and saves it before the response is
+ * written if it was modified. New sessions are only saved once they are
+ * modified. Sessions expire when they are idle for longer than the idle
+ * timeout or are older than the maximum age.
+ *
+ * A cookie session cannot be invalidated by the server unless the manager
+ * has a Revoker: a copy of its cookie remains valid until the session
+ * expires, even after the session is regenerated or destroyed.
+ */
+type Manager struct {
+  opts Options
+}
+
+/**
+ * Create a session manager
+ */
+func New(opts Options) (*Manager, error) {
+  if opts.Name == "" {
+    opts.Name = DefaultName
+  }
+  if opts.IdleTimeout == 0 {
+    opts.IdleTimeout = DefaultIdleTimeout
+  }
+  if opts.MaxAge == 0 {
+    opts.MaxAge = DefaultMaxAge
+  }
+  if opts.Path == "" {
+    opts.Path = "/"
+  }
+  if opts.SameSite == 0 {
+    opts.SameSite = http.SameSiteLaxMode
+  }
+  if opts.Store == nil {
+    if len(opts.Keys) == 0 {
+      return nil, fmt.Errorf("Cookie sessions require at least one key")
+    }
+    for i, e := range opts.Keys {
+      if err := e.validate(); err != nil {
+        return nil, fmt.Errorf("Key %d: %v", i, err)
+      }
+    }
+  }
+  return &Manager{opts}, nil
+}
+
+/**
+ * A session
+ */
+type Session struct {
+  lock      sync.Mutex
+  data      Data
+  prev      string    // the identifier the session was loaded with, if any
+  expires   time.Time // when the session it was loaded as expires, regardless of use
+  loaded    bool
+  dirty     bool
+  destroyed bool
+  saved     bool
+}
+
+/**
+ * Obtain the session of a request, if it is handled by a session manager
+ */
+func FromRequest(req *rest.Request) *Session {
+  if req.Attrs == nil {
+    return nil
+  }
+  s, _ := req.Attrs[attrSession].(*Session)
+  return s
+}
+
+/**
+ * Obtain the session identifier; it is empty until the session is saved
+ */
+func (s *Session) Id() string {
+  s.lock.Lock()
+  defer s.lock.Unlock()
+  return s.data.Id
+}
+
+/**
+ * Determine if the session was created by this request
+ */
+func (s *Session) IsNew() bool {
+  return !s.loaded
+}
+
+/**
+ * Obtain a value. Values are stored as JSON, so numbers are loaded as
+ * float64 and structs as maps.
+ */
+func (s *Session) Get(k string) interface{} {
+  s.lock.Lock()
+  defer s.lock.Unlock()
+  return s.data.Values[k]
+}
+
+/**
+ * Set a value, which must be representable as JSON
+ */
+func (s *Session) Set(k string, v interface{}) {
+  s.lock.Lock()
+  defer s.lock.Unlock()
+  if s.data.Values == nil {
+    s.data.Values = make(map[string]interface{})
+  }
+  s.data.Values[k] = v
+  s.dirty = true
+}
+
+/**
+ * Delete a value
+ */
+func (s *Session) Delete(k string) {
+  s.lock.Lock()
+  defer s.lock.Unlock()
+  if _, ok := s.data.Values[k]; ok {
+    delete(s.data.Values, k)
+    s.dirty = true
+  }
+}
+
+/**
+ * Add a flash message, which is kept until it is read by a later request
+ */
+func (s *Session) AddFlash(m string) {
+  s.lock.Lock()
+  defer s.lock.Unlock()
+  s.data.Flashes = append(s.data.Flashes, m)
+  s.dirty = true
+}
+
+/**
+ * Obtain and clear the flash messages
+ */
+func (s *Session) Flashes() []string {
+  s.lock.Lock()
+  defer s.lock.Unlock()
+  f := s.data.Flashes
+  if len(f) > 0 {
+    s.data.Flashes = nil
+    s.dirty = true
+  }
+  return f
+}
+
+/**
+ * Issue the session a new identifier, keeping its values. This should be
+ * done whenever the privileges of the session change, such as when a user
+ * signs in or out. The previous identifier of a server-side session cannot
+ * be used after the change; the previous cookie of a cookie session can be
+ * used until the session expires unless the manager has a Revoker.
+ */
+func (s *Session) Regenerate() {
+  s.lock.Lock()
+  defer s.lock.Unlock()
+  s.data.Id = ""
+  s.dirty = true
+}
+
+/**
+ * Destroy the session, deleting it and clearing its cookie. The session
+ * is replaced by a new, empty session, which is saved if it is modified.
+ * As with Regenerate, a copy of the cookie of a cookie session remains
+ * valid until the session expires unless the manager has a Revoker.
+ */
+func (s *Session) Destroy() {
+  s.lock.Lock()
+  defer s.lock.Unlock()
+  now := time.Now()
+  s.data = Data{Created:now, Accessed:now}
+  s.destroyed = true
+  s.dirty = true
+}
+
+/**
+ * Load the session of a request, producing a new session if it has none or
+ * it has expired
+ */
+func (m *Manager) load(req *rest.Request) (*Session, error) {
+  now := time.Now()
+  s := &Session{data:Data{Created:now, Accessed:now}}
+
+  c, err := req.Cookie(m.opts.Name)
+  if err != nil || c.Value == "" {
+    return s, nil
+  }
+
+  var d *Data
+  if m.opts.Store != nil {
+    d, err = m.opts.Store.Load(c.Value)
+    if err != nil {
+      return nil, rest.NewErrorf(http.StatusInternalServerError, "Could not load session: %v", err)
+    }
+  }else{
+    d = decodeCookie(m.opts.Keys, m.opts.Name, c.Value)
+    if d != nil && m.opts.Revoker != nil {
+      revoked, err := m.opts.Revoker.Revoked(d.Id)
+      if err != nil {
+        return nil, rest.NewErrorf(http.StatusInternalServerError, "Could not load session: %v", err)
+      }else if revoked {
+        d = nil
+      }
+    }
+  }
+  if d == nil {
+    s.dirty = true // the cookie is invalid and should be replaced or cleared
+    return s, nil
+  }
+
+  if now.Sub(d.Accessed) > m.opts.IdleTimeout || now.Sub(d.Created) > m.opts.MaxAge {
+    if m.opts.Store != nil {
+      if err := m.opts.Store.Delete(d.Id); err != nil {
+        alt.Errorf("session: Could not delete expired session: %v", err)
+      }
+    }
+    s.dirty = true
+    return s, nil
+  }
+
+  s.data, s.prev, s.expires, s.loaded = *d, d.Id, d.Created.Add(m.opts.MaxAge), true
+  return s, nil
+}
+
+/**
+ * Save a session if it has changed, setting its cookie. This is done at
+ * most once per request.
+ */
+func (m *Manager) save(rsp http.ResponseWriter, s *Session) error {
+  s.lock.Lock()
+  defer s.lock.Unlock()
+  if s.saved {
+    return nil
+  }
+  s.saved = true
+
+  now := time.Now()
+  if !s.dirty && (!s.loaded || now.Sub(s.data.Accessed) < touchInterval) {
+    return nil
+  }
+
+  if s.prev != "" && (s.destroyed || s.data.Id != s.prev) {
+    if m.opts.Store != nil {
+      if err := m.opts.Store.Delete(s.prev); err != nil {
+        return rest.NewErrorf(http.StatusInternalServerError, "Could not delete session: %v", err)
+      }
+    }else if m.opts.Revoker != nil {
+      if err := m.opts.Revoker.Revoke(s.prev, s.expires); err != nil {
+        return rest.NewErrorf(http.StatusInternalServerError, "Could not revoke session: %v", err)
+      }
+    }
+  }
+  if (s.destroyed || !s.loaded) && len(s.data.Values) == 0 && len(s.data.Flashes) == 0 {
+    if s.loaded || s.dirty {
+      http.SetCookie(rsp, m.cookie("", -1))
+    }
+    return nil
+  }
+
+  if s.data.Id == "" {
+    id, err := newId()
+    if err != nil {
+      return err
+    }
+    s.data.Id = id
+  }
+  s.data.Accessed = now
+
+  var v string
+  if m.opts.Store != nil {
+    if err := m.opts.Store.Save(&s.data, m.expires(&s.data).Sub(now)); err != nil {
+      return rest.NewErrorf(http.StatusInternalServerError, "Could not save session: %v", err)
+    }
+    v = s.data.Id
+  }else{
+    var err error
+    v, err = encodeCookie(m.opts.Keys[0], m.opts.Name, &s.data)
+    if err != nil {
+      return rest.NewErrorf(http.StatusInternalServerError, "Could not save session: %v", err)
+    }
+  }
+  http.SetCookie(rsp, m.cookie(v, int(s.data.Created.Add(m.opts.MaxAge).Sub(now) / time.Second)))
+  return nil
+}
+
+/**
+ * Determine when a session expires if it is not used again
+ */
+func (m *Manager) expires(d *Data) time.Time {
+  idle, abs := d.Accessed.Add(m.opts.IdleTimeout), d.Created.Add(m.opts.MaxAge)
+  if idle.Before(abs) {
+    return idle
+  }
+  return abs
+}
+
+/**
+ * Produce the session cookie
+ */
+func (m *Manager) cookie(v string, maxAge int) *http.Cookie {
+  return &http.Cookie{
+    Name: m.opts.Name,
+    Value: v,
+    Path: m.opts.Path,
+    Domain: m.opts.Domain,
+    MaxAge: maxAge,
+    Secure: !m.opts.Insecure,
+    HttpOnly: true,
+    SameSite: m.opts.SameSite,
+  }
+}
+
+/**
+ * Serve a request
+ */
+func (m *Manager) ServeRequest(rsp http.ResponseWriter, req *rest.Request, pln rest.Pipeline) (interface{}, error) {
+  s, err := m.load(req)
+  if err != nil {
+    return nil, err
+  }
+  if req.Attrs == nil {
+    req.Attrs = make(rest.Attrs)
+  }
+  req.Attrs[attrSession] = s
+
+  res, err := pln.Next(&sessionWriter{rsp, m, s}, req)
+  if serr := m.save(rsp, s); serr != nil {
+    return nil, serr
+  }
+  return res, err
+}
+
+/**
+ * A response writer which saves the session before a handler writes its
+ * own response
+ */
+type sessionWriter struct {
+  http.ResponseWriter
+  manager *Manager
+  session *Session
+}
+
+/**
+ * Save the session
+ */
+func (w *sessionWriter) commit() {
+  if err := w.manager.save(w.ResponseWriter, w.session); err != nil {
+    alt.Errorf("session: %v", err)
+  }
+}
+
+/**
+ * Write the status
+ */
+func (w *sessionWriter) WriteHeader(s int) {
+  w.commit()
+  w.ResponseWriter.WriteHeader(s)
+}
+
+/**
+ * Write data
+ */
+func (w *sessionWriter) Write(p []byte) (int, error) {
+  w.commit()
+  return w.ResponseWriter.Write(p)
+}
+
+/**
+ * Flush, if the underlying writer supports it
+ */
+func (w *sessionWriter) Flush() {
+  w.commit()
+  if f, ok := w.ResponseWriter.(http.Flusher); ok {
+    f.Flush()
+  }
+}
+
+/**
+ * Produce an authenticator for principals whose subject is stored in the
+ * session under a key, for use with the authentication handler. The
+ * session manager must precede the authentication handler.
+ */
+func (m *Manager) Authenticator(key string) auth.Authenticator {
+  return sessionAuthenticator{key}
+}
+
+/**
+ * Authenticates requests by their sessions
+ */
+type sessionAuthenticator struct {
+  key string
+}
+
+/**
+ * Authenticate a request
+ */
+func (a sessionAuthenticator) Authenticate(req *rest.Request) (*rest.Principal, error) {
+  s := FromRequest(req)
+  if s == nil {
+    return nil, nil
+  }
+  sub, _ := s.Get(a.key).(string)
+  if sub == "" {
+    return nil, nil
+  }
+  return &rest.Principal{Subject:sub, Scheme:"session", Claims:map[string]interface{}{"session_id": s.Id()}}, nil
+}
+
+/**
+ * Sessions have no challenge
+ */
+func (a sessionAuthenticator) Challenge(err error) string {
+  return ""
+}
+
+/**
+ * Produce a session identifier
+ */
+func newId() (string, error) {
+  b := make([]byte, 32)
+  if _, err := rand.Read(b); err != nil {
+    return "", fmt.Errorf("Could not generate session identifier: %v", err)
+  }
+  return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,164 @@
+package session
+
+import (
+  "sync"
+  "time"
+  "encoding/json"
+)
+
+/**
+ * The persistent state of a session
+ */
+type Data struct {
+  Id        string                  `json:"id"`
+  Values    map[string]interface{}  `json:"values,omitempty"`
+  Flashes   []string                `json:"flashes,omitempty"`
+  Created   time.Time               `json:"created"`
+  Accessed  time.Time               `json:"accessed"`
+}
+
+/**
+ * A server-side session store
+ */
+type Store interface {
+  // Load a session; the result is nil if there is no such session
+  Load(id string)(*Data, error)
+  // Save a session, which may be discarded once it has not been saved for
+  // the specified period
+  Save(d *Data, ttl time.Duration)(error)
+  // Delete a session
+  Delete(id string)(error)
+}
+
+/**
+ * An in-memory session store. Sessions are held as JSON, so values are
+ * loaded as they would be from any other store.
+ */
+type MemoryStore struct {
+  sync.Mutex
+  sessions  map[string]memoryEntry
+  swept     time.Time
+}
+
+/**
+ * A stored session
+ */
+type memoryEntry struct {
+  data    []byte
+  expires time.Time
+}
+
+/**
+ * Create a memory store
+ */
+func NewMemoryStore() *MemoryStore {
+  return &MemoryStore{sessions:make(map[string]memoryEntry)}
+}
+
+/**
+ * Load a session
+ */
+func (s *MemoryStore) Load(id string) (*Data, error) {
+  s.Lock()
+  e, ok := s.sessions[id]
+  s.Unlock()
+  if !ok || time.Now().After(e.expires) {
+    return nil, nil
+  }
+  d := &Data{}
+  if err := json.Unmarshal(e.data, d); err != nil {
+    return nil, err
+  }
+  return d, nil
+}
+
+/**
+ * Save a session, discarding expired sessions periodically
+ */
+func (s *MemoryStore) Save(d *Data, ttl time.Duration) error {
+  data, err := json.Marshal(d)
+  if err != nil {
+    return err
+  }
+  s.Lock()
+  defer s.Unlock()
+  now := time.Now()
+  if now.Sub(s.swept) > time.Minute {
+    for k, v := range s.sessions {
+      if now.After(v.expires) {
+        delete(s.sessions, k)
+      }
+    }
+    s.swept = now
+  }
+  s.sessions[d.Id] = memoryEntry{data, now.Add(ttl)}
+  return nil
+}
+
+/**
+ * Delete a session
+ */
+func (s *MemoryStore) Delete(id string) error {
+  s.Lock()
+  defer s.Unlock()
+  delete(s.sessions, id)
+  return nil
+}
+
+/**
+ * A record of revoked cookie sessions. Cookie sessions are held entirely by
+ * the client, so a cookie replaced when a session is regenerated or
+ * destroyed otherwise remains valid until the session expires.
+ */
+type Revoker interface {
+  // Revoke a session identifier; it need not be remembered after the
+  // session would have expired
+  Revoke(id string, expires time.Time)(error)
+  // Determine if a session identifier has been revoked
+  Revoked(id string)(bool, error)
+}
+
+/**
+ * An in-memory revocation list
+ */
+type MemoryRevoker struct {
+  sync.Mutex
+  revoked map[string]time.Time
+  swept   time.Time
+}
+
+/**
+ * Create a memory revocation list
+ */
+func NewMemoryRevoker() *MemoryRevoker {
+  return &MemoryRevoker{revoked:make(map[string]time.Time)}
+}
+
+/**
+ * Revoke a session, discarding expired revocations periodically
+ */
+func (r *MemoryRevoker) Revoke(id string, expires time.Time) error {
+  r.Lock()
+  defer r.Unlock()
+  now := time.Now()
+  if now.Sub(r.swept) > time.Minute {
+    for k, v := range r.revoked {
+      if now.After(v) {
+        delete(r.revoked, k)
+      }
+    }
+    r.swept = now
+  }
+  r.revoked[id] = expires
+  return nil
+}
+
+/**
+ * Determine if a session has been revoked
+ */
+func (r *MemoryRevoker) Revoked(id string) (bool, error) {
+  r.Lock()
+  defer r.Unlock()
+  _, ok := r.revoked[id]
+  return ok, nil
+}
@@ -0,0 +1,409 @@
+package signature
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+  "encoding/base64"
+)
+
+/**
+ * This file implements the subset of Structured Field Values (RFC 8941)
+ * needed by message signatures: dictionaries whose members are inner
+ * lists or bare items, with parameters.
+ */
+
+/**
+ * A token, as distinct from a string
+ */
+type token string
+
+/**
+ * A parameter
+ */
+type param struct {
+  key   string
+  value interface{}
+}
+
+/**
+ * Ordered parameters
+ */
+type params []param
+
+/**
+ * Obtain a parameter
+ */
+func (p params) get(k string) (interface{}, bool) {
+  for _, e := range p {
+    if e.key == k {
+      return e.value, true
+    }
+  }
+  return nil, false
+}
+
+/**
+ * An item and its parameters
+ */
+type item struct {
+  value   interface{}
+  params  params
+}
+
+/**
+ * A dictionary member, whose value is a bare value or an inner list
+ */
+type member struct {
+  key     string
+  value   interface{}
+  list    []item
+  inner   bool
+  params  params
+}
+
+/**
+ * A structured field parser
+ */
+type parser struct {
+  s string
+  i int
+}
+
+/**
+ * Parse a dictionary
+ */
+func parseDictionary(s string) ([]member, error) {
+  p := &parser{s:s}
+  var d []member
+  p.skipSpace()
+  for p.i < len(p.s) {
+    m, err := p.member()
+    if err != nil {
+      return nil, err
+    }
+    for i, e := range d {
+      if e.key == m.key { // later members replace earlier ones
+        d = append(d[:i], d[i+1:]...)
+        break
+      }
+    }
+    d = append(d, m)
+    p.skipSpace()
+    if p.i == len(p.s) {
+      break
+    }
+    if p.s[p.i] != ',' {
+      return nil, p.errorf("Expected ','")
+    }
+    p.i++
+    p.skipSpace()
+    if p.i == len(p.s) {
+      return nil, p.errorf("Trailing ','")
+    }
+  }
+  return d, nil
+}
+
+/**
+ * Parse a dictionary member
+ */
+func (p *parser) member() (member, error) {
+  k, err := p.key()
+  if err != nil {
+    return member{}, err
+  }
+  m := member{key:k}
+  if p.i < len(p.s) && p.s[p.i] == '=' {
+    p.i++
+    if p.i < len(p.s) && p.s[p.i] == '(' {
+      m.list, err = p.innerList()
+      m.inner = true
+    }else{
+      m.value, err = p.bare()
+    }
+    if err != nil {
+      return member{}, err
+    }
+  }else{
+    m.value = true
+  }
+  m.params, err = p.params()
+  return m, err
+}
+
+/**
+ * Parse an inner list, excluding its parameters
+ */
+func (p *parser) innerList() ([]item, error) {
+  p.i++ // '('
+  var l []item
+  for {
+    for p.i < len(p.s) && p.s[p.i] == ' ' {
+      p.i++
+    }
+    if p.i == len(p.s) {
+      return nil, p.errorf("Unterminated inner list")
+    }
+    if p.s[p.i] == ')' {
+      p.i++
+      return l, nil
+    }
+    v, err := p.bare()
+    if err != nil {
+      return nil, err
+    }
+    a, err := p.params()
+    if err != nil {
+      return nil, err
+    }
+    l = append(l, item{v, a})
+    if p.i < len(p.s) && p.s[p.i] != ' ' && p.s[p.i] != ')' {
+      return nil, p.errorf("Expected ' ' or ')'")
+    }
+  }
+}
+
+/**
+ * Parse parameters
+ */
+func (p *parser) params() (params, error) {
+  var a params
+  for p.i < len(p.s) && p.s[p.i] == ';' {
+    p.i++
+    for p.i < len(p.s) && p.s[p.i] == ' ' {
+      p.i++
+    }
+    k, err := p.key()
+    if err != nil {
+      return nil, err
+    }
+    var v interface{} = true
+    if p.i < len(p.s) && p.s[p.i] == '=' {
+      p.i++
+      v, err = p.bare()
+      if err != nil {
+        return nil, err
+      }
+    }
+    for i, e := range a {
+      if e.key == k {
+        a = append(a[:i], a[i+1:]...)
+        break
+      }
+    }
+    a = append(a, param{k, v})
+  }
+  return a, nil
+}
+
+/**
+ * Parse a key
+ */
+func (p *parser) key() (string, error) {
+  s := p.i
+  if p.i == len(p.s) || !(p.s[p.i] == '*' || (p.s[p.i] >= 'a' && p.s[p.i] <= 'z')) {
+    return "", p.errorf("Expected key")
+  }
+  for p.i < len(p.s) && strings.IndexByte("abcdefghijklmnopqrstuvwxyz0123456789_-.*", p.s[p.i]) >= 0 {
+    p.i++
+  }
+  return p.s[s:p.i], nil
+}
+
+/**
+ * Parse a bare item
+ */
+func (p *parser) bare() (interface{}, error) {
+  if p.i == len(p.s) {
+    return nil, p.errorf("Expected item")
+  }
+  switch c := p.s[p.i]; {
+    case c == '"':
+      return p.string()
+    case c == ':':
+      return p.bytes()
+    case c == '?':
+      if p.i + 1 < len(p.s) && (p.s[p.i+1] == '0' || p.s[p.i+1] == '1') {
+        p.i += 2
+        return p.s[p.i-1] == '1', nil
+      }
+      return nil, p.errorf("Invalid boolean")
+    case c == '-' || (c >= '0' && c <= '9'):
+      return p.number()
+    case c == '*' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+      s := p.i
+      for p.i < len(p.s) && (isTchar(p.s[p.i]) || p.s[p.i] == ':' || p.s[p.i] == '/') {
+        p.i++
+      }
+      return token(p.s[s:p.i]), nil
+    default:
+      return nil, p.errorf("Unexpected character")
+  }
+}
+
+/**
+ * Parse a string
+ */
+func (p *parser) string() (string, error) {
+  p.i++ // '"'
+  var b strings.Builder
+  for p.i < len(p.s) {
+    c := p.s[p.i]
+    p.i++
+    switch {
+      case c == '\\':
+        if p.i == len(p.s) || (p.s[p.i] != '"' && p.s[p.i] != '\\') {
+          return "", p.errorf("Invalid escape")
+        }
+        b.WriteByte(p.s[p.i])
+        p.i++
+      case c == '"':
+        return b.String(), nil
+      case c < 0x20 || c > 0x7e:
+        return "", p.errorf("Invalid string character")
+      default:
+        b.WriteByte(c)
+    }
+  }
+  return "", p.errorf("Unterminated string")
+}
+
+/**
+ * Parse a byte sequence
+ */
+func (p *parser) bytes() ([]byte, error) {
+  e := strings.IndexByte(p.s[p.i+1:], ':')
+  if e < 0 {
+    return nil, p.errorf("Unterminated byte sequence")
+  }
+  v := p.s[p.i+1:p.i+1+e]
+  p.i += e + 2
+  b, err := base64.StdEncoding.DecodeString(v)
+  if err != nil {
+    return nil, p.errorf("Invalid byte sequence")
+  }
+  return b, nil
+}
+
+/**
+ * Parse an integer or decimal
+ */
+func (p *parser) number() (interface{}, error) {
+  s := p.i
+  if p.s[p.i] == '-' {
+    p.i++
+  }
+  d := p.i
+  for p.i < len(p.s) && p.s[p.i] >= '0' && p.s[p.i] <= '9' {
+    p.i++
+  }
+  digits := p.i - d
+  if digits < 1 {
+    return nil, p.errorf("Invalid number")
+  }
+  if p.i >= len(p.s) || p.s[p.i] != '.' {
+    if digits > 15 {
+      return nil, p.errorf("Invalid integer")
+    }
+    v, err := strconv.ParseInt(p.s[s:p.i], 10, 64)
+    if err != nil {
+      return nil, p.errorf("Invalid integer")
+    }
+    return v, nil
+  }
+
+  p.i++ // the decimal point
+  f := p.i
+  for p.i < len(p.s) && p.s[p.i] >= '0' && p.s[p.i] <= '9' {
+    p.i++
+  }
+  if digits > 12 || p.i - f < 1 || p.i - f > 3 {
+    return nil, p.errorf("Invalid decimal")
+  }
+  v, err := strconv.ParseFloat(p.s[s:p.i], 64)
+  if err != nil {
+    return nil, p.errorf("Invalid decimal")
+  }
+  return v, nil
+}
+
+/**
+ * Skip optional whitespace
+ */
+func (p *parser) skipSpace() {
+  for p.i < len(p.s) && (p.s[p.i] == ' ' || p.s[p.i] == '\t') {
+    p.i++
+  }
+}
+
+/**
+ * Produce a parse error
+ */
+func (p *parser) errorf(f string, a ...interface{}) error {
+  return fmt.Errorf("%s at offset %d", fmt.Sprintf(f, a...), p.i)
+}
+
+/**
+ * Determine if a character is a token character
+ */
+func isTchar(c byte) bool {
+  return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
+/**
+ * Serialize an inner list and its parameters
+ */
+func serializeInnerList(l []item, a params) string {
+  s := make([]string, len(l))
+  for i, e := range l {
+    s[i] = serializeItem(e)
+  }
+  return "("+ strings.Join(s, " ") +")"+ serializeParams(a)
+}
+
+/**
+ * Serialize an item and its parameters
+ */
+func serializeItem(e item) string {
+  return serializeBare(e.value) + serializeParams(e.params)
+}
+
+/**
+ * Serialize parameters
+ */
+func serializeParams(a params) string {
+  var b strings.Builder
+  for _, e := range a {
+    b.WriteString(";"+ e.key)
+    if v, ok := e.value.(bool); !ok || !v {
+      b.WriteString("="+ serializeBare(e.value))
+    }
+  }
+  return b.String()
+}
+
+/**
+ * Serialize a bare value
+ */
+func serializeBare(v interface{}) string {
+  switch c := v.(type) {
+    case string:
+      return `"`+ strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(c) +`"`
+    case token:
+      return string(c)
+    case int64:
+      return strconv.FormatInt(c, 10)
+    case float64:
+      return strconv.FormatFloat(c, 'f', -1, 64)
+    case []byte:
+      return ":"+ base64.StdEncoding.EncodeToString(c) +":"
+    case bool:
+      if c {
+        return "?1"
+      }
+      return "?0"
+    default:
+      return fmt.Sprint(c)
+  }
+}
@@ -0,0 +1,144 @@
+package signature
+
+import (
+  "io"
+  "fmt"
+  "time"
+  "bytes"
+  "io/ioutil"
+  "net/http"
+  "crypto/rand"
+  "encoding/base64"
+)
+
+import (
+  "github.com/bww/go-rest"
+)
+
+/**
+ * Signs requests with HTTP message signatures (RFC 9421), for use by
+ * clients of services which verify them. Requests with entities have a
+ * Content-Digest computed, unless they already have one, which the
+ * signature covers.
+ */
+type Signer struct {
+  KeyId       string
+  Key         *Key          // the signing key
+  Label       string        // the signature label; defaults to sig1
+  Components  []string      // the components to cover; defaults to DefaultComponents
+  Digest      string        // the Content-Digest algorithm; defaults to sha-256
+  Nonce       bool          // if set, signatures have a random nonce so they cannot be replayed
+  Expires     time.Duration // if set, signatures expire after this period
+  Tag         string        // an application-specific tag, if any
+}
+
+/**
+ * Sign a request. The request entity, if any, is buffered and replaced.
+ */
+func (s *Signer) Sign(req *http.Request) error {
+  components := s.Components
+  if components == nil {
+    components = DefaultComponents
+  }
+  l := make([]item, 0, len(components) + 1)
+  for _, e := range components {
+    l = append(l, item{value:e})
+  }
+
+  if req.Body != nil && req.Body != http.NoBody {
+    data, err := ioutil.ReadAll(req.Body)
+    req.Body.Close()
+    if err != nil {
+      return fmt.Errorf("Could not read request entity: %v", err)
+    }
+    req.Body = ioutil.NopCloser(bytes.NewReader(data))
+    req.GetBody = func() (io.ReadCloser, error) {
+      return ioutil.NopCloser(bytes.NewReader(data)), nil
+    }
+    req.ContentLength = int64(len(data))
+    if req.Header.Get("Content-Digest") == "" {
+      alg := s.Digest
+      if alg == "" {
+        alg = rest.DigestSHA256
+      }
+      d, err := rest.FormatDigest([]string{alg}, data)
+      if err != nil {
+        return err
+      }
+      req.Header.Set("Content-Digest", d)
+    }
+    l = append(l, item{value:"content-digest"})
+  }
+
+  now := time.Now()
+  a := params{{"created", now.Unix()}}
+  if s.Expires > 0 {
+    a = append(a, param{"expires", now.Add(s.Expires).Unix()})
+  }
+  a = append(a, param{"keyid", s.KeyId}, param{"alg", s.Key.Algorithm})
+  if s.Nonce {
+    b := make([]byte, 16)
+    if _, err := rand.Read(b); err != nil {
+      return fmt.Errorf("Could not generate nonce: %v", err)
+    }
+    a = append(a, param{"nonce", base64.RawURLEncoding.EncodeToString(b)})
+  }
+  if s.Tag != "" {
+    a = append(a, param{"tag", s.Tag})
+  }
+
+  scheme := req.URL.Scheme
+  if scheme == "" {
+    scheme = "http"
+  }
+  host := req.Host
+  if host == "" {
+    host = req.URL.Host
+  }
+  base, err := signatureBase(message{req.Method, scheme, host, req.URL, req.Header}, l, a)
+  if err != nil {
+    return err
+  }
+  sig, err := sign(s.Key.Algorithm, s.Key.Key, []byte(base))
+  if err != nil {
+    return err
+  }
+
+  label := s.Label
+  if label == "" {
+    label = "sig1"
+  }
+  req.Header.Set("Signature-Input", label +"="+ serializeInnerList(l, a))
+  req.Header.Set("Signature", label +"="+ serializeBare(sig))
+  return nil
+}
+
+/**
+ * Produce a transport which signs requests before they are sent with
+ * another transport, or http.DefaultTransport if it is nil
+ */
+func (s *Signer) Transport(t http.RoundTripper) http.RoundTripper {
+  if t == nil {
+    t = http.DefaultTransport
+  }
+  return signingTransport{s, t}
+}
+
+/**
+ * A signing transport
+ */
+type signingTransport struct {
+  signer  *Signer
+  next    http.RoundTripper
+}
+
+/**
+ * Sign and send a request
+ */
+func (t signingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+  req = req.Clone(req.Context()) // transports must not modify the request
+  if err := t.signer.Sign(req); err != nil {
+    return nil, err
+  }
+  return t.next.RoundTrip(req)
+}
@@ -0,0 +1,307 @@
+package signature
+
+import (
+  "fmt"
+  "strings"
+  "math/big"
+  "net/url"
+  "net/http"
+  "crypto"
+  "crypto/rand"
+  "crypto/hmac"
+  "crypto/rsa"
+  "crypto/ecdsa"
+  "crypto/sha256"
+  "crypto/sha512"
+  "crypto/ed25519"
+)
+
+/**
+ * Signature algorithms (RFC 9421, section 3.3)
+ */
+const (
+  RSAPSSSHA512    = "rsa-pss-sha512"
+  RSASHA256       = "rsa-v1_5-sha256"
+  HMACSHA256      = "hmac-sha256"
+  ECDSAP256SHA256 = "ecdsa-p256-sha256"
+  ECDSAP384SHA384 = "ecdsa-p384-sha384"
+  Ed25519         = "ed25519"
+)
+
+/**
+ * The components covered by signatures by default
+ */
+var DefaultComponents = []string{"@method", "@authority", "@path", "@query"}
+
+/**
+ * A signing or verification key
+ */
+type Key struct {
+  Algorithm string      // the algorithm the key is used with
+  Key       interface{} // []byte for HMAC; otherwise, to verify, an *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey and, to sign, the corresponding private key
+  Subject   string      // the subject of the principal of requests signed with the key; defaults to the key identifier
+}
+
+/**
+ * A set of verification keys
+ */
+type KeySet interface {
+  // Obtain the key with an identifier; the result is nil if there is no
+  // such key
+  Key(keyid string)(*Key, error)
+}
+
+/**
+ * Keys which do not change, by identifier
+ */
+type StaticKeys map[string]*Key
+
+/**
+ * Obtain a key
+ */
+func (s StaticKeys) Key(keyid string) (*Key, error) {
+  return s[keyid], nil
+}
+
+/**
+ * The parts of a request message which components are derived from
+ */
+type message struct {
+  method    string
+  scheme    string
+  authority string
+  url       *url.URL
+  header    http.Header
+}
+
+/**
+ * Produce the signature base for a message (RFC 9421, section 2.5)
+ */
+func signatureBase(m message, components []item, sigparams params) (string, error) {
+  var b strings.Builder
+  seen := make(map[string]bool)
+  for _, e := range components {
+    id := serializeItem(e)
+    if seen[id] {
+      return "", fmt.Errorf("Component is covered more than once: %v", id)
+    }
+    seen[id] = true
+    v, err := componentValue(m, e)
+    if err != nil {
+      return "", err
+    }
+    if strings.ContainsAny(v, "\r\n") {
+      return "", fmt.Errorf("Component value contains a line break: %v", id)
+    }
+    b.WriteString(id +": "+ v +"\n")
+  }
+  b.WriteString(`"@signature-params": `+ serializeInnerList(components, sigparams))
+  return b.String(), nil
+}
+
+/**
+ * Produce the value of a component
+ */
+func componentValue(m message, c item) (string, error) {
+  name, ok := c.value.(string)
+  if !ok {
+    return "", fmt.Errorf("Component identifier is not a string: %v", serializeItem(c))
+  }
+  for _, e := range c.params {
+    if e.key != "name" || name != "@query-param" {
+      return "", fmt.Errorf("Unsupported component parameter: %v", serializeItem(c))
+    }
+  }
+  switch name {
+    case "@method":
+      return m.method, nil
+    case "@target-uri":
+      return strings.ToLower(m.scheme) +"://"+ authority(m) + m.url.RequestURI(), nil
+    case "@authority":
+      return authority(m), nil
+    case "@scheme":
+      return strings.ToLower(m.scheme), nil
+    case "@request-target":
+      return m.url.RequestURI(), nil
+    case "@path":
+      if p := m.url.EscapedPath(); p != "" {
+        return p, nil
+      }
+      return "/", nil
+    case "@query":
+      return "?"+ m.url.RawQuery, nil
+    case "@query-param":
+      n, ok := c.params.get("name")
+      if !ok {
+        return "", fmt.Errorf("Component has no name: %v", serializeItem(c))
+      }
+      s, _ := n.(string)
+      v, ok := m.url.Query()[s]
+      if !ok {
+        return "", fmt.Errorf("Query parameter is not present: %v", s)
+      }
+      if len(v) != 1 {
+        return "", fmt.Errorf("Query parameter is ambiguous: %v", s)
+      }
+      return url.QueryEscape(v[0]), nil
+  }
+  if strings.HasPrefix(name, "@") {
+    return "", fmt.Errorf("Unsupported derived component: %v", name)
+  }
+  if name != strings.ToLower(name) {
+    return "", fmt.Errorf("Field name must be lowercase: %v", name)
+  }
+  v, ok := m.header[http.CanonicalHeaderKey(name)]
+  if !ok {
+    return "", fmt.Errorf("Field is not present: %v", name)
+  }
+  t := make([]string, len(v))
+  for i, e := range v {
+    t[i] = strings.TrimSpace(e)
+  }
+  return strings.Join(t, ", "), nil
+}
+
+/**
+ * Produce the normalized authority of a message, without the default port
+ * of its scheme
+ */
+func authority(m message) string {
+  a := strings.ToLower(m.authority)
+  switch strings.ToLower(m.scheme) {
+    case "http":
+      return strings.TrimSuffix(a, ":80")
+    case "https":
+      return strings.TrimSuffix(a, ":443")
+    default:
+      return a
+  }
+}
+
+/**
+ * Sign a signature base
+ */
+func sign(alg string, key interface{}, base []byte) ([]byte, error) {
+  switch alg {
+    case RSAPSSSHA512:
+      k, ok := key.(*rsa.PrivateKey)
+      if !ok {
+        break
+      }
+      h := sha512.Sum512(base)
+      return rsa.SignPSS(rand.Reader, k, crypto.SHA512, h[:], &rsa.PSSOptions{SaltLength:64})
+    case RSASHA256:
+      k, ok := key.(*rsa.PrivateKey)
+      if !ok {
+        break
+      }
+      h := sha256.Sum256(base)
+      return rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, h[:])
+    case HMACSHA256:
+      k, ok := key.([]byte)
+      if !ok {
+        break
+      }
+      m := hmac.New(sha256.New, k)
+      m.Write(base)
+      return m.Sum(nil), nil
+    case ECDSAP256SHA256, ECDSAP384SHA384:
+      k, ok := key.(*ecdsa.PrivateKey)
+      if !ok || k.Curve.Params().Name != curveName(alg) {
+        break
+      }
+      r, s, err := ecdsa.Sign(rand.Reader, k, ecdsaHash(alg, base))
+      if err != nil {
+        return nil, err
+      }
+      n := (k.Curve.Params().BitSize + 7) / 8
+      sig := make([]byte, 2 * n)
+      r.FillBytes(sig[:n])
+      s.FillBytes(sig[n:])
+      return sig, nil
+    case Ed25519:
+      k, ok := key.(ed25519.PrivateKey)
+      if !ok {
+        break
+      }
+      return ed25519.Sign(k, base), nil
+    default:
+      return nil, fmt.Errorf("Unsupported algorithm: %v", alg)
+  }
+  return nil, fmt.Errorf("Key is not suitable for %v", alg)
+}
+
+/**
+ * Verify a signature over a signature base
+ */
+func verify(alg string, key interface{}, base, sig []byte) error {
+  var ok bool
+  switch alg {
+    case RSAPSSSHA512:
+      k, isrsa := key.(*rsa.PublicKey)
+      if !isrsa {
+        return fmt.Errorf("Key is not suitable for %v", alg)
+      }
+      h := sha512.Sum512(base)
+      ok = rsa.VerifyPSS(k, crypto.SHA512, h[:], sig, &rsa.PSSOptions{SaltLength:64}) == nil
+    case RSASHA256:
+      k, isrsa := key.(*rsa.PublicKey)
+      if !isrsa {
+        return fmt.Errorf("Key is not suitable for %v", alg)
+      }
+      h := sha256.Sum256(base)
+      ok = rsa.VerifyPKCS1v15(k, crypto.SHA256, h[:], sig) == nil
+    case HMACSHA256:
+      k, isbytes := key.([]byte)
+      if !isbytes {
+        return fmt.Errorf("Key is not suitable for %v", alg)
+      }
+      m := hmac.New(sha256.New, k)
+      m.Write(base)
+      ok = hmac.Equal(sig, m.Sum(nil))
+    case ECDSAP256SHA256, ECDSAP384SHA384:
+      k, isec := key.(*ecdsa.PublicKey)
+      if !isec || k.Curve.Params().Name != curveName(alg) {
+        return fmt.Errorf("Key is not suitable for %v", alg)
+      }
+      n := (k.Curve.Params().BitSize + 7) / 8
+      if len(sig) != 2 * n {
+        return fmt.Errorf("Invalid signature length")
+      }
+      ok = ecdsa.Verify(k, ecdsaHash(alg, base), new(big.Int).SetBytes(sig[:n]), new(big.Int).SetBytes(sig[n:]))
+    case Ed25519:
+      k, ised := key.(ed25519.PublicKey)
+      if !ised {
+        return fmt.Errorf("Key is not suitable for %v", alg)
+      }
+      ok = ed25519.Verify(k, base, sig)
+    default:
+      return fmt.Errorf("Unsupported algorithm: %v", alg)
+  }
+  if !ok {
+    return fmt.Errorf("Signature is not valid")
+  }
+  return nil
+}
+
+/**
+ * The curve used by an ECDSA algorithm
+ */
+func curveName(alg string) string {
+  if alg == ECDSAP384SHA384 {
+    return "P-384"
+  }
+  return "P-256"
+}
+
+/**
+ * Hash a signature base for an ECDSA algorithm
+ */
+func ecdsaHash(alg string, base []byte) []byte {
+  if alg == ECDSAP384SHA384 {
+    h := sha512.Sum384(base)
+    return h[:]
+  }
+  h := sha256.Sum256(base)
+  return h[:]
+}
@@ -0,0 +1,401 @@
+package signature
+
+import (
+  "io"
+  "fmt"
+  "sync"
+  "time"
+  "bytes"
+  "strings"
+  "io/ioutil"
+  "net/http"
+)
+
+import (
+  "github.com/bww/go-rest"
+)
+
+/**
+ * Verifier defaults
+ */
+const (
+  DefaultWindow         = 5 * time.Minute // how old a signature may be
+  DefaultSkew           = time.Minute     // the clock skew permitted when checking signature times
+  DefaultMaxEntitySize  = 10 << 20        // the largest entity buffered to verify its digest
+)
+
+/**
+ * Signature rejection codes
+ */
+const (
+  SignatureMissing              = "missing"
+  SignatureMalformed            = "malformed"
+  SignatureUnknownKey           = "unknown_key"
+  SignatureUnsupportedAlgorithm = "unsupported_algorithm"
+  SignatureMissingComponent     = "missing_component"
+  SignatureInvalid              = "invalid_signature"
+  SignatureExpired              = "expired"
+  SignatureReplayed             = "replayed"
+)
+
+/**
+ * The detail of a rejected signature
+ */
+type SignatureError struct {
+  Code    string `json:"code"`
+  Message string `json:"message"`
+}
+
+/**
+ * It's an error
+ */
+func (e SignatureError) Error() string {
+  return e.Message
+}
+
+/**
+ * Produce a 401 for a rejected signature
+ */
+func signatureError(code, f string, a ...interface{}) *rest.Error {
+  m := fmt.Sprintf(f, a...)
+  return rest.NewErrorf(http.StatusUnauthorized, "Invalid signature: %s", m).SetDetail(SignatureError{code, m})
+}
+
+/**
+ * A cache of the nonces of signatures which have been accepted
+ */
+type NonceCache interface {
+  // Record a nonce, which need not be remembered after it expires,
+  // reporting whether it had already been recorded
+  Seen(keyid, nonce string, expires time.Time)(bool)
+}
+
+/**
+ * An in-memory nonce cache
+ */
+type MemoryNonces struct {
+  sync.Mutex
+  nonces  map[string]time.Time
+  swept   time.Time
+}
+
+/**
+ * Create a memory nonce cache
+ */
+func NewMemoryNonces() *MemoryNonces {
+  return &MemoryNonces{nonces:make(map[string]time.Time)}
+}
+
+/**
+ * Record a nonce
+ */
+func (c *MemoryNonces) Seen(keyid, nonce string, expires time.Time) bool {
+  c.Lock()
+  defer c.Unlock()
+  now := time.Now()
+  if now.Sub(c.swept) > time.Minute {
+    for k, v := range c.nonces {
+      if now.After(v) {
+        delete(c.nonces, k)
+      }
+    }
+    c.swept = now
+  }
+  k := keyid +"\n"+ nonce
+  if v, ok := c.nonces[k]; ok && !now.After(v) {
+    return true
+  }
+  c.nonces[k] = expires
+  return false
+}
+
+/**
+ * Verifier options
+ */
+type Options struct {
+  Keys          KeySet
+  Label         string        // the label of the signature to verify; defaults to the first signature in the request
+  Components    []string      // components which signatures must cover; defaults to DefaultComponents. Signatures of requests with entities must also cover content-digest.
+  Window        time.Duration // how old a signature may be, by its created time; defaults to DefaultWindow
+  Skew          time.Duration // the clock skew permitted; defaults to DefaultSkew
+  Nonces        NonceCache    // accepted nonces; defaults to a memory cache
+  RequireNonce  bool          // if set, signatures must have a nonce
+  MaxEntitySize int64         // the largest entity buffered to verify its digest; defaults to DefaultMaxEntitySize
+}
+
+/**
+ * An HTTP message signature (RFC 9421) verifier. Requests must be signed
+ * with a key identified by the keyid parameter, must have a created time
+ * within the verification window and, if they have a nonce, must not reuse
+ * one which has already been accepted. When a request has an entity its
+ * signature must cover Content-Digest, and the entity is buffered and
+ * checked against the digest.
+ *
+ * As a handler, the verifier rejects requests which are not signed and
+ * sets the principal of those which are, unless it has already been set;
+ * as an authenticator, it can be used with the authentication handler
+ * alongside other schemes. The principal's subject is that of the key and
+ * it has the key identifier as its keyid claim. Rejected signatures
+ * produce a 401 whose detail is a SignatureError.
+ */
+type Verifier struct {
+  opts Options
+}
+
+/**
+ * Create a verifier
+ */
+func NewVerifier(opts Options) *Verifier {
+  if opts.Components == nil {
+    opts.Components = DefaultComponents
+  }
+  if opts.Window == 0 {
+    opts.Window = DefaultWindow
+  }
+  if opts.Skew == 0 {
+    opts.Skew = DefaultSkew
+  }
+  if opts.Nonces == nil {
+    opts.Nonces = NewMemoryNonces()
+  }
+  if opts.MaxEntitySize == 0 {
+    opts.MaxEntitySize = DefaultMaxEntitySize
+  }
+  return &Verifier{opts}
+}
+
+/**
+ * Serve a request
+ */
+func (v *Verifier) ServeRequest(rsp http.ResponseWriter, req *rest.Request, pln rest.Pipeline) (interface{}, error) {
+  p, err := v.Authenticate(req)
+  if err != nil {
+    return nil, err
+  }
+  if p == nil {
+    return nil, signatureError(SignatureMissing, "Request must be signed")
+  }
+  if req.Principal == nil {
+    req.Principal = p
+  }
+  return pln.Next(rsp, req)
+}
+
+/**
+ * Signatures have no standard challenge
+ */
+func (v *Verifier) Challenge(err error) string {
+  return ""
+}
+
+/**
+ * Verify the signature of a request, producing the principal of its key or
+ * nil if it is not signed
+ */
+func (v *Verifier) Authenticate(req *rest.Request) (*rest.Principal, error) {
+  input := strings.Join(req.Header.Values("Signature-Input"), ", ")
+  if input == "" {
+    return nil, nil
+  }
+  inputs, err := parseDictionary(input)
+  if err != nil {
+    return nil, signatureError(SignatureMalformed, "Invalid Signature-Input: %v", err)
+  }
+  sigs, err := parseDictionary(strings.Join(req.Header.Values("Signature"), ", "))
+  if err != nil {
+    return nil, signatureError(SignatureMalformed, "Invalid Signature: %v", err)
+  }
+
+  var in *member
+  for i, e := range inputs {
+    if v.opts.Label == "" || e.key == v.opts.Label {
+      in = &inputs[i]
+      break
+    }
+  }
+  if in == nil {
+    return nil, signatureError(SignatureMissing, "No signature is labeled %q", v.opts.Label)
+  }
+  if !in.inner {
+    return nil, signatureError(SignatureMalformed, "Signature input %q is not an inner list", in.key)
+  }
+  var sig []byte
+  for _, e := range sigs {
+    if e.key == in.key {
+      sig, _ = e.value.([]byte)
+    }
+  }
+  if sig == nil {
+    return nil, signatureError(SignatureMalformed, "No signature value for %q", in.key)
+  }
+
+  keyid, _ := stringParam(in.params, "keyid")
+  if keyid == "" {
+    return nil, signatureError(SignatureMalformed, "Signature has no keyid")
+  }
+  key, err := v.opts.Keys.Key(keyid)
+  if err != nil {
+    return nil, rest.NewErrorf(http.StatusInternalServerError, "Could not obtain verification key: %v", err)
+  }
+  if key == nil {
+    return nil, signatureError(SignatureUnknownKey, "Unknown key: %q", keyid)
+  }
+  if alg, ok := stringParam(in.params, "alg"); ok && alg != key.Algorithm {
+    return nil, signatureError(SignatureUnsupportedAlgorithm, "Algorithm %v is not permitted for key %q", alg, keyid)
+  }
+
+  hasEntity := req.Body != nil && req.Body != http.NoBody
+  if err := v.checkCoverage(in.list, hasEntity); err != nil {
+    return nil, err
+  }
+
+  base, err := signatureBase(requestMessage(req), in.list, in.params)
+  if err != nil {
+    return nil, signatureError(SignatureMalformed, "%v", err)
+  }
+  if err := verify(key.Algorithm, key.Key, []byte(base), sig); err != nil {
+    return nil, signatureError(SignatureInvalid, "%v", err)
+  }
+
+  until, err := v.checkTimes(in.params)
+  if err != nil {
+    return nil, err
+  }
+  nonce, hasNonce := stringParam(in.params, "nonce")
+  if !hasNonce && v.opts.RequireNonce {
+    return nil, signatureError(SignatureMalformed, "Signature has no nonce")
+  }
+
+  // the nonce is only consumed once the request is otherwise acceptable
+  if hasEntity {
+    if err := v.checkDigest(req); err != nil {
+      return nil, err
+    }
+  }
+  if hasNonce && v.opts.Nonces.Seen(keyid, nonce, until) {
+    return nil, signatureError(SignatureReplayed, "Nonce has already been used")
+  }
+
+  sub := key.Subject
+  if sub == "" {
+    sub = keyid
+  }
+  return &rest.Principal{Subject:sub, Scheme:"signature", Claims:map[string]interface{}{"keyid": keyid}}, nil
+}
+
+/**
+ * Check that a signature covers the required components
+ */
+func (v *Verifier) checkCoverage(l []item, hasEntity bool) error {
+  covered := make(map[string]bool)
+  for _, e := range l {
+    if s, ok := e.value.(string); ok && len(e.params) == 0 {
+      covered[s] = true
+    }
+  }
+  for _, e := range v.opts.Components {
+    if !covered[e] {
+      return signatureError(SignatureMissingComponent, "Signature must cover %v", e)
+    }
+  }
+  if hasEntity && !covered["content-digest"] {
+    return signatureError(SignatureMissingComponent, "Signature must cover content-digest")
+  }
+  return nil
+}
+
+/**
+ * Check the created and expires times of a signature, producing the time
+ * until which it could be accepted, for which its nonce must be remembered
+ */
+func (v *Verifier) checkTimes(a params) (time.Time, error) {
+  now := time.Now()
+  c, ok := a.get("created")
+  if !ok {
+    return time.Time{}, signatureError(SignatureMalformed, "Signature has no created time")
+  }
+  created, ok := c.(int64)
+  if !ok {
+    return time.Time{}, signatureError(SignatureMalformed, "Invalid created time")
+  }
+  t := time.Unix(created, 0)
+  if now.Sub(t) > v.opts.Window + v.opts.Skew {
+    return time.Time{}, signatureError(SignatureExpired, "Signature was created at %v, which is outside the verification window", t.UTC().Format(time.RFC3339))
+  }else if t.Sub(now) > v.opts.Skew {
+    return time.Time{}, signatureError(SignatureExpired, "Signature was created in the future")
+  }
+  until := t.Add(v.opts.Window + v.opts.Skew)
+  if x, ok := a.get("expires"); ok {
+    expires, ok := x.(int64)
+    if !ok {
+      return time.Time{}, signatureError(SignatureMalformed, "Invalid expires time")
+    }
+    e := time.Unix(expires, 0).Add(v.opts.Skew)
+    if now.After(e) {
+      return time.Time{}, signatureError(SignatureExpired, "Signature expired at %v", time.Unix(expires, 0).UTC().Format(time.RFC3339))
+    }
+    if e.After(until) {
+      until = e
+    }
+  }
+  return until, nil
+}
+
+/**
+ * Buffer the request entity and check it against its digest. The entity is
+ * replaced so that handlers can read it.
+ */
+func (v *Verifier) checkDigest(req *rest.Request) error {
+  d := req.Header.Get("Content-Digest")
+  if d == "" {
+    return signatureError(SignatureMissingComponent, "Request has an entity but no Content-Digest")
+  }
+  data, err := ioutil.ReadAll(&limitReader{req.Body, v.opts.MaxEntitySize})
+  if rerr, ok := err.(*rest.Error); ok {
+    return rerr
+  }else if err != nil {
+    return rest.NewErrorf(http.StatusBadRequest, "Could not read request entity: %v", err)
+  }
+  req.Body.Close()
+  req.Body = ioutil.NopCloser(bytes.NewReader(data))
+  return req.VerifyEntityDigest(data)
+}
+
+/**
+ * Produce the message components of a request as it was received
+ */
+func requestMessage(req *rest.Request) message {
+  return message{req.Method, req.Scheme(), req.Host, req.URL, req.Header}
+}
+
+/**
+ * Obtain a string parameter
+ */
+func stringParam(a params, k string) (string, bool) {
+  v, ok := a.get(k)
+  if !ok {
+    return "", false
+  }
+  s, ok := v.(string)
+  return s, ok
+}
+
+/**
+ * A reader which fails once more than a limit has been read
+ */
+type limitReader struct {
+  r io.Reader
+  n int64
+}
+
+/**
+ * Read
+ */
+func (l *limitReader) Read(p []byte) (int, error) {
+  n, err := l.r.Read(p)
+  l.n -= int64(n)
+  if l.n < 0 {
+    return n, rest.NewErrorf(http.StatusRequestEntityTooLarge, "Request entity is too large to verify")
+  }
+  return n, err
+}
@@ -11,7 +11,8 @@ import (
 )
 
 /**
- * Read and return the request entity
+ * Read and return the request entity. If the request has a Content-Digest
+ * or Repr-Digest the entity is verified against it.
  */
 func RequestEntity(req *rest.Request) ([]byte, error) {
   
@@ -20,15 +21,23 @@ func RequestEntity(req *rest.Request) ([]byte, error) {
   }
   
   data, err := ioutil.ReadAll(req.Body)
-  if err != nil {
+  if rerr, ok := err.(*rest.Error); ok {
+    return nil, rerr // the entity is too large or could not be decoded
+  }else if err != nil {
     return nil, rest.NewErrorf(http.StatusBadRequest, "Could not read request entity: %v", err)
   }
   
+  if err := req.VerifyEntityDigest(data); err != nil {
+    return nil, err
+  }
+  
   return data, nil
 }
 
 /**
- * Unmarshal a request entity. The entity is assumed to be JSON.
+ * Unmarshal a request entity. The entity is assumed to be JSON. If the
+ * entity is a struct it is then validated according to its validate tags
+ * as described by rest.Validate.
  */
 func UnmarshalRequestEntity(req *rest.Request, entity interface{}) error {
   
@@ -42,5 +51,5 @@ func UnmarshalRequestEntity(req *rest.Request, entity interface{}) error {
     return rest.NewErrorf(http.StatusBadRequest, "Could not unmarshal request entity: %v", err)
   }
   
-  return nil
+  return rest.Validate(entity)
 }
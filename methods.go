@@ -0,0 +1,118 @@
+package rest
+
+import (
+  "sort"
+  "strings"
+  "net/http"
+)
+
+import (
+  "github.com/gorilla/mux"
+)
+
+/**
+ * Handle a request whose path matches a route but whose method does not.
+ * HEAD requests are served by the GET route for the path, if there is one,
+ * without an entity; plain OPTIONS requests are answered with the methods
+ * allowed for the path; anything else is 405 Method Not Allowed.
+ */
+func (s *Service) routeUnmatchedMethod(rsp http.ResponseWriter, req *Request, allowed []string) (interface{}, error) {
+  switch req.Method {
+    case "HEAD":
+      var match mux.RouteMatch
+      if s.router.Match(withMethod(req.Request, "GET"), &match) && match.MatchErr == nil {
+        match.Handler.ServeHTTP(headResponseWriter{rsp}, mux.SetURLVars(req.Request, match.Vars))
+        return nil, nil
+      }
+    case "OPTIONS":
+      rsp.Header().Set("X-Request-Id", req.Id)
+      rsp.Header().Set("Allow", strings.Join(allowed, ", "))
+      rsp.WriteHeader(http.StatusNoContent)
+      return nil, nil
+  }
+  return nil, NewErrorf(http.StatusMethodNotAllowed, "Method not allowed for %v: %v", req.URL.Path, req.Method).SetHeaders(map[string]string{"Allow": strings.Join(allowed, ", ")})
+}
+
+/**
+ * Determine which methods are allowed for the path of a request by trying
+ * every method used by a route. If none are, no route matches the path and
+ * nil is returned. Otherwise, HEAD is allowed wherever GET is and OPTIONS is
+ * always allowed.
+ */
+func (s *Service) allowedMethods(req *http.Request) []string {
+  allow := make(map[string]bool)
+  for _, m := range s.routeMethods() {
+    if m == req.Method {
+      continue
+    }
+    var match mux.RouteMatch
+    if s.router.Match(withMethod(req, m), &match) && match.MatchErr == nil {
+      allow[m] = true
+    }
+  }
+  if len(allow) == 0 {
+    return nil
+  }
+  if allow["GET"] {
+    allow["HEAD"] = true
+  }
+  allow["OPTIONS"] = true
+
+  methods := make([]string, 0, len(allow))
+  for k, _ := range allow {
+    methods = append(methods, k)
+  }
+  sort.Strings(methods)
+  return methods
+}
+
+/**
+ * Obtain the distinct methods used by routes. These are resolved when they
+ * are first needed, since a route's methods are set after it is registered,
+ * and again whenever a route is registered.
+ */
+func (s *Service) routeMethods() []string {
+  s.methodsLock.Lock()
+  defer s.methodsLock.Unlock()
+  if s.methods != nil {
+    return s.methods
+  }
+  seen := make(map[string]bool)
+  methods := make([]string, 0)
+  s.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+    if m, err := route.GetMethods(); err == nil {
+      for _, e := range m {
+        if e = strings.ToUpper(e); !seen[e] {
+          seen[e] = true
+          methods = append(methods, e)
+        }
+      }
+    }
+    return nil
+  })
+  s.methods = methods
+  return methods
+}
+
+/**
+ * Copy a request with a different method
+ */
+func withMethod(req *http.Request, method string) *http.Request {
+  c := req.WithContext(req.Context())
+  c.Method = method
+  return c
+}
+
+/**
+ * A response writer that discards the entity, for HEAD requests
+ */
+type headResponseWriter struct {
+  http.ResponseWriter
+}
+
+/**
+ * Discard entity data
+ */
+func (w headResponseWriter) Write(b []byte) (int, error) {
+  return len(b), nil
+}
@@ -0,0 +1,401 @@
+package mock
+
+import (
+  "sort"
+  "time"
+  "strconv"
+  "strings"
+  "math/rand"
+  "net/http"
+  "encoding/json"
+)
+
+import (
+  "github.com/bww/go-rest"
+)
+
+/**
+ * The maximum depth to which schemas are synthesized; recursive schemas are
+ * truncated beyond it
+ */
+const maxDepth = 8
+
+/**
+ * Mock options
+ */
+type Options struct {
+  Config      rest.Config     // the configuration of the mock service
+  BasePath    string          // the path under which operations are registered
+  Latency     time.Duration   // a delay applied to every response
+  Jitter      time.Duration   // a maximum random delay added to the latency
+  ErrorRate   float64         // the fraction of requests, from 0 to 1, which fail with an injected error
+  ErrorStatus int             // the status of injected errors; defaults to 500
+}
+
+/**
+ * Create a service that mocks the operations described by an OpenAPI
+ * document. Every operation is registered and responds with an example
+ * from the document or, when there is none, a value synthesized from the
+ * response schema.
+ *
+ * By default the lowest success status described for an operation is used.
+ * Clients can select a particular status or named example with the Prefer
+ * header, for example:
+ *
+ *   Prefer: status=404
+ *   Prefer: example=empty
+ *
+ */
+func New(doc *rest.OpenAPI, opts Options) *rest.Service {
+  svc := rest.NewService(opts.Config)
+
+  var ctx *rest.Context
+  if opts.BasePath != "" {
+    ctx = svc.ContextWithBasePath(opts.BasePath)
+  }else{
+    ctx = svc.Context()
+  }
+
+  paths := make([]string, 0, len(doc.Paths))
+  for k, _ := range doc.Paths {
+    paths = append(paths, k)
+  }
+  sort.Strings(paths)
+
+  for _, p := range paths {
+    for m, op := range doc.Paths[p].Operations() {
+      attrs := rest.Attrs{
+        rest.ATTR_SUMMARY: op.Summary,
+        rest.ATTR_DESCRIPTION: op.Description,
+        rest.ATTR_OPERATION_ID: op.OperationId,
+        rest.ATTR_TAGS: op.Tags,
+      }
+      ctx.Handle(p, &operation{doc, op, opts}, attrs).Methods(m)
+    }
+  }
+
+  return svc
+}
+
+/**
+ * Create a mock service from an OpenAPI document on disk
+ */
+func Load(path string, opts Options) (*rest.Service, error) {
+  doc, err := rest.LoadOpenAPI(path)
+  if err != nil {
+    return nil, err
+  }
+  return New(doc, opts), nil
+}
+
+/**
+ * A mocked operation
+ */
+type operation struct {
+  doc   *rest.OpenAPI
+  op    *rest.Operation
+  opts  Options
+}
+
+/**
+ * Serve a request
+ */
+func (o *operation) ServeRequest(rsp http.ResponseWriter, req *rest.Request, pln rest.Pipeline) (interface{}, error) {
+  if d := o.opts.Latency + jitter(o.opts.Jitter); d > 0 {
+    select {
+      case <-time.After(d):
+      case <-req.Context().Done():
+        return nil, rest.NewError(http.StatusServiceUnavailable, req.Context().Err())
+    }
+  }
+
+  if o.opts.ErrorRate > 0 && rand.Float64() < o.opts.ErrorRate {
+    s := o.opts.ErrorStatus
+    if s == 0 {
+      s = http.StatusInternalServerError
+    }
+    return nil, rest.NewErrorf(s, "Injected error for %v %v", req.Method, req.URL.Path)
+  }
+
+  prefer := parsePrefer(req.Header.Get("Prefer"))
+  status, r, err := o.response(prefer["status"])
+  if err != nil {
+    return nil, err
+  }
+
+  var content interface{}
+  var ctype string
+  if r != nil && len(r.Content) > 0 {
+    ctype, content = o.content(r.Content, prefer["example"])
+  }
+
+  for k, h := range responseHeaders(o.doc, r) {
+    rsp.Header().Set(k, h)
+  }
+
+  if status == http.StatusOK && (ctype == "" || ctype == rest.CONTENT_TYPE_JSON) {
+    return content, nil
+  }
+
+  // other statuses and media types are written directly
+  data, err := encode(ctype, content)
+  if err != nil {
+    return nil, rest.NewErrorf(http.StatusInternalServerError, "Could not encode example: %v", err)
+  }
+  rsp.Header().Set("X-Request-Id", req.Id)
+  if data != nil {
+    rsp.Header().Set("Content-Type", ctype)
+  }
+  rsp.WriteHeader(status)
+  if data != nil && status != http.StatusNoContent {
+    rsp.Write(data)
+  }
+  req.Finalize()
+  return nil, nil
+}
+
+/**
+ * Select the response to produce
+ */
+func (o *operation) response(want string) (int, *rest.Response, error) {
+  if want != "" {
+    s, err := strconv.Atoi(want)
+    if err != nil {
+      return 0, nil, rest.NewErrorf(http.StatusBadRequest, "Invalid preferred status: %v", want)
+    }
+    r := o.doc.ResponseForStatus(o.op.Responses, s)
+    if r == nil {
+      return 0, nil, rest.NewErrorf(http.StatusBadRequest, "No response is described for status: %v", s)
+    }
+    return s, r, nil
+  }
+
+  var codes []int
+  for k, _ := range o.op.Responses {
+    if s, err := strconv.Atoi(k); err == nil && s >= 200 && s < 300 {
+      codes = append(codes, s)
+    }
+  }
+  if len(codes) > 0 {
+    sort.Ints(codes)
+    return codes[0], o.doc.ResolveResponse(o.op.Responses[strconv.Itoa(codes[0])]), nil
+  }
+  if r, ok := o.op.Responses["2XX"]; ok {
+    return http.StatusOK, o.doc.ResolveResponse(r), nil
+  }
+  if r, ok := o.op.Responses["default"]; ok {
+    return http.StatusOK, o.doc.ResolveResponse(r), nil
+  }
+  return http.StatusNoContent, nil, nil
+}
+
+/**
+ * Produce content for a response; JSON is preferred when it is available
+ */
+func (o *operation) content(content map[string]*rest.MediaType, example string) (string, interface{}) {
+  ctype := rest.CONTENT_TYPE_JSON
+  mt, ok := content[ctype]
+  if !ok {
+    types := make([]string, 0, len(content))
+    for k, _ := range content {
+      types = append(types, k)
+    }
+    sort.Strings(types)
+    ctype = types[0]
+    mt = content[ctype]
+  }
+  if mt == nil {
+    return ctype, nil
+  }
+
+  if len(mt.Examples) > 0 {
+    if e, ok := mt.Examples[example]; ok && e != nil {
+      return ctype, e.Value
+    }
+    names := make([]string, 0, len(mt.Examples))
+    for k, _ := range mt.Examples {
+      names = append(names, k)
+    }
+    sort.Strings(names)
+    if e := mt.Examples[names[0]]; e != nil {
+      return ctype, e.Value
+    }
+  }
+  if mt.Example != nil {
+    return ctype, mt.Example
+  }
+  return ctype, synthesize(o.doc, mt.Schema, 0)
+}
+
+/**
+ * Produce example response headers
+ */
+func responseHeaders(doc *rest.OpenAPI, r *rest.Response) map[string]string {
+  h := make(map[string]string)
+  if r == nil {
+    return h
+  }
+  for k, e := range r.Headers {
+    p := doc.ResolveParameter(e)
+    if p == nil {
+      continue
+    }
+    var v interface{}
+    if p.Example != nil {
+      v = p.Example
+    }else{
+      v = synthesize(doc, p.Schema, 0)
+    }
+    if v != nil {
+      if s, ok := v.(string); ok {
+        h[k] = s
+      }else if data, err := json.Marshal(v); err == nil {
+        h[k] = string(data)
+      }
+    }
+  }
+  return h
+}
+
+/**
+ * Encode content for a media type
+ */
+func encode(ctype string, content interface{}) ([]byte, error) {
+  switch v := content.(type) {
+    case nil:
+      return nil, nil
+    case string:
+      if ctype != rest.CONTENT_TYPE_JSON && !strings.HasSuffix(ctype, "+json") {
+        return []byte(v), nil
+      }
+  }
+  return json.Marshal(content)
+}
+
+/**
+ * Synthesize a value conforming to a schema
+ */
+func synthesize(doc *rest.OpenAPI, s *rest.Schema, depth int) interface{} {
+  s = doc.ResolveSchema(s)
+  if s == nil || depth > maxDepth {
+    return nil
+  }
+
+  switch {
+    case s.Example != nil:
+      return s.Example
+    case len(s.Examples) > 0:
+      return s.Examples[0]
+    case s.Default != nil:
+      return s.Default
+    case len(s.Enum) > 0:
+      return s.Enum[0]
+    case len(s.OneOf) > 0:
+      return synthesize(doc, s.OneOf[0], depth + 1)
+    case len(s.AnyOf) > 0:
+      return synthesize(doc, s.AnyOf[0], depth + 1)
+    case len(s.AllOf) > 0:
+      m := make(map[string]interface{})
+      for _, e := range s.AllOf {
+        if v, ok := synthesize(doc, e, depth + 1).(map[string]interface{}); ok {
+          for k, x := range v {
+            m[k] = x
+          }
+        }
+      }
+      return m
+  }
+
+  switch {
+    case s.Type.Has("object") || (len(s.Type) == 0 && len(s.Properties) > 0):
+      m := make(map[string]interface{})
+      for k, e := range s.Properties {
+        if v := synthesize(doc, e, depth + 1); v != nil {
+          m[k] = v
+        }
+      }
+      return m
+    case s.Type.Has("array"):
+      n := 1
+      if s.MinItems != nil && *s.MinItems > n {
+        n = *s.MinItems
+      }
+      if depth >= maxDepth || (s.MaxItems != nil && *s.MaxItems == 0) {
+        n = 0
+      }
+      l := make([]interface{}, 0, n)
+      for i := 0; i < n; i++ {
+        l = append(l, synthesize(doc, s.Items, depth + 1))
+      }
+      return l
+    case s.Type.Has("string"):
+      return synthesizeString(s)
+    case s.Type.Has("integer"):
+      if s.Minimum != nil {
+        return int64(*s.Minimum)
+      }
+      return 0
+    case s.Type.Has("number"):
+      if s.Minimum != nil {
+        return *s.Minimum
+      }
+      return 0.0
+    case s.Type.Has("boolean"):
+      return true
+    default:
+      return nil
+  }
+}
+
+/**
+ * Synthesize a string
+ */
+func synthesizeString(s *rest.Schema) string {
+  switch s.Format {
+    case "date-time":
+      return time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC).Format(time.RFC3339)
+    case "date":
+      return "2000-01-01"
+    case "email":
+      return "user@example.com"
+    case "uri", "url":
+      return "https://example.com/"
+    case "uuid":
+      return rest.RandomUUID().String()
+    case "byte":
+      return ""
+  }
+  v := "string"
+  if s.MinLength != nil && len(v) < *s.MinLength {
+    v += strings.Repeat("x", *s.MinLength - len(v))
+  }
+  if s.MaxLength != nil && len(v) > *s.MaxLength {
+    v = v[:*s.MaxLength]
+  }
+  return v
+}
+
+/**
+ * Parse a Prefer header into its preferences
+ */
+func parsePrefer(h string) map[string]string {
+  p := make(map[string]string)
+  for _, e := range strings.Split(h, ",") {
+    for _, f := range strings.Split(e, ";") {
+      if x := strings.Index(f, "="); x > 0 {
+        p[strings.ToLower(strings.TrimSpace(f[:x]))] = strings.Trim(strings.TrimSpace(f[x+1:]), `"`)
+      }
+    }
+  }
+  return p
+}
+
+/**
+ * Produce a random delay up to a maximum
+ */
+func jitter(max time.Duration) time.Duration {
+  if max <= 0 {
+    return 0
+  }
+  return time.Duration(rand.Int63n(int64(max)))
+}
@@ -0,0 +1,155 @@
+package rest
+
+import (
+  "net/http"
+)
+
+import (
+  "github.com/gorilla/mux"
+)
+
+/**
+ * Mount a handler under a path prefix in the service; the prefix is removed
+ * from request paths before they are passed to the handler. A handler may
+ * be another service, which handles requests with its own pipeline, entity
+ * handler and name.
+ */
+func (s *Service) Mount(p string, h http.Handler) *mux.Route {
+  return s.Context().Mount(p, h)
+}
+
+/**
+ * Mount a handler for every request to a host in the service
+ */
+func (s *Service) MountHost(host string, h http.Handler) *mux.Route {
+  return s.Context().MountHost(host, h)
+}
+
+/**
+ * Mount a handler under a path prefix relative to the context; the full
+ * prefix is removed from request paths before they are passed to the
+ * handler. The context middleware does not apply to mounted handlers.
+ */
+func (c *Context) Mount(p string, h http.Handler) *mux.Route {
+  r := c.router.PathPrefix(p).Handler(http.StripPrefix(c.prefix + p, h))
+  c.service.register(r, nil, WrapHTTPHandler(h))
+  return r
+}
+
+/**
+ * Mount a handler for every request to a host in the context
+ */
+func (c *Context) MountHost(host string, h http.Handler) *mux.Route {
+  r := c.router.Host(host).Handler(h)
+  c.service.register(r, nil, WrapHTTPHandler(h))
+  return r
+}
+
+/**
+ * Produce a handler which serves requests with a standard library handler.
+ * The handler writes the response itself, so the request is finalized.
+ */
+func WrapHTTPHandler(h http.Handler) Handler {
+  return httpHandler{h}
+}
+
+/**
+ * A standard library handler
+ */
+type httpHandler struct {
+  http.Handler
+}
+
+/**
+ * Serve a request
+ */
+func (h httpHandler) ServeRequest(rsp http.ResponseWriter, req *Request, pln Pipeline) (interface{}, error) {
+  h.ServeHTTP(rsp, req.Request)
+  req.Finalize()
+  return nil, nil
+}
+
+/**
+ * Produce a handler from standard library middleware. The rest of the
+ * pipeline is run within the middleware, with the request and response
+ * writer it provides, and its result is written through that response
+ * writer so that middleware which wraps it (to compress responses, for
+ * example) sees the entity. If the middleware responds without calling the
+ * next handler the request is finalized.
+ */
+func WrapMiddleware(m func(http.Handler) http.Handler) Handler {
+  return HandlerFunc(func(rsp http.ResponseWriter, req *Request, pln Pipeline) (interface{}, error) {
+    var res interface{}
+    var err error
+    var called, sent bool
+
+    m(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
+      called = true
+      req.Request = r
+      tw := &trackingWriter{ResponseWriter:w}
+      res, err = pln.Next(tw, req)
+      if tw.written || req.Finalized() {
+        sent = true
+      }else if (res != nil || err != nil) && req.service != nil {
+        req.service.sendResponse(tw, req, res, err)
+        sent = true
+      }
+    })).ServeHTTP(rsp, req.Request)
+
+    if !called || sent {
+      req.Finalize()
+      return nil, nil
+    }
+    return res, err
+  })
+}
+
+/**
+ * A response writer that notes whether a response was written
+ */
+type trackingWriter struct {
+  http.ResponseWriter
+  written bool
+}
+
+/**
+ * Write the status
+ */
+func (w *trackingWriter) WriteHeader(status int) {
+  w.written = true
+  w.ResponseWriter.WriteHeader(status)
+}
+
+/**
+ * Write entity data
+ */
+func (w *trackingWriter) Write(b []byte) (int, error) {
+  w.written = true
+  return w.ResponseWriter.Write(b)
+}
+
+/**
+ * Produce a standard library handler which serves requests with a handler
+ * as if it were a route in the service
+ */
+func (s *Service) HTTPHandler(h Handler) http.Handler {
+  c := s.Context()
+  return http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request){
+    c.handle(rsp, newRequest(s, req), h)
+  })
+}
+
+/**
+ * Produce standard library middleware from a handler. The next handler is
+ * called when the handler continues its pipeline; if it returns a result
+ * instead, the result is written by the service.
+ */
+func (s *Service) HTTPMiddleware(h Handler) func(http.Handler) http.Handler {
+  return func(next http.Handler) http.Handler {
+    return s.HTTPHandler(Pipeline{h, HandlerFunc(func(rsp http.ResponseWriter, req *Request, pln Pipeline) (interface{}, error) {
+      next.ServeHTTP(rsp, req.Request)
+      req.Finalize()
+      return nil, nil
+    })})
+  }
+}
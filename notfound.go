@@ -0,0 +1,174 @@
+package rest
+
+import (
+  "sort"
+  "strings"
+  "net/http"
+)
+
+/**
+ * The maximum number of near-miss routes suggested for a request that
+ * matches no route
+ */
+const maxSuggestions = 3
+
+/**
+ * Detail describing a request that matches no route
+ */
+type notFoundDetail struct {
+  Suggestions []string `json:"suggestions"`
+}
+
+/**
+ * Set the handler for requests under the context's base path that match
+ * no route. Its result is handled like that of any route; a handler may,
+ * for example, return a 404 *Error with its own detail. Where contexts are
+ * nested the handler of the most specific context is used, and the handler
+ * of a context with a host is preferred to that of one without.
+ */
+func (c *Context) NotFound(h Handler) {
+  if c.notFound == nil {
+    c.service.notFound = append(c.service.notFound, c)
+  }
+  c.notFound = h
+}
+
+/**
+ * Set the handler for requests that match no route; like routes created
+ * with HandleFunc, it is run through the context middleware
+ */
+func (c *Context) NotFoundFunc(f func(http.ResponseWriter, *Request, Pipeline)(interface{}, error)) {
+  c.NotFound(&contextHandler{context:c, handler:HandlerFunc(f)})
+}
+
+/**
+ * Handle a request that matches no route. If a context under which the
+ * request falls has a not-found handler it is used, otherwise the result is
+ * a 404 which, in debug mode, suggests routes which nearly match.
+ */
+func (s *Service) routeNotFound(rsp http.ResponseWriter, req *Request) (interface{}, error) {
+  var ctx *Context
+  for _, e := range s.notFound {
+    if !hasPathPrefix(req.URL.Path, e.prefix) {
+      continue
+    }
+    if ok, _ := e.matchesHost(req.Request); !ok {
+      continue
+    }
+    if ctx == nil || (e.host != nil && ctx.host == nil) || ((e.host != nil) == (ctx.host != nil) && len(e.prefix) > len(ctx.prefix)) {
+      ctx = e
+    }
+  }
+  if ctx != nil {
+    ctx.handle(rsp, req, ctx.notFound)
+    return nil, nil
+  }
+
+  err := NewErrorf(http.StatusNotFound, "No resource matches: %v %v", req.Method, req.URL.Path)
+  if s.debug {
+    if l := s.suggestRoutes(req.URL.Path); len(l) > 0 {
+      err.SetDetail(notFoundDetail{l})
+    }
+  }
+  return nil, err
+}
+
+/**
+ * Determine if a path falls under a prefix, on a segment boundary
+ */
+func hasPathPrefix(p, prefix string) bool {
+  if !strings.HasPrefix(p, prefix) {
+    return false
+  }
+  return len(p) == len(prefix) || strings.HasSuffix(prefix, "/") || p[len(prefix)] == '/'
+}
+
+/**
+ * Find routes whose paths nearly match a path. Route variables are taken to
+ * match the corresponding segment of the path, so only literal segments and
+ * the number of segments are compared.
+ */
+func (s *Service) suggestRoutes(p string) []string {
+  routes, err := s.Routes()
+  if err != nil {
+    return nil
+  }
+
+  type candidate struct {
+    route     Route
+    distance  int
+  }
+
+  psegs := strings.Split(strings.Trim(p, "/"), "/")
+  var found []candidate
+  for _, r := range routes {
+    if r.Template == "" {
+      continue
+    }
+    if h, _ := r.Attrs[ATTR_HIDDEN].(bool); h {
+      continue
+    }
+    tsegs := templateSegments(r.Template)
+    segs := make([]string, len(tsegs))
+    for i, e := range tsegs {
+      if e.isvar && i < len(psegs) {
+        segs[i] = psegs[i]
+      }else{
+        segs[i] = e.literal
+      }
+    }
+    q := strings.Join(segs, "/")
+    d := editDistance(strings.Join(psegs, "/"), q)
+    if d > 0 && d <= maxEditDistance(q) {
+      found = append(found, candidate{r, d})
+    }
+  }
+
+  sort.SliceStable(found, func(i, j int) bool {
+    return found[i].distance < found[j].distance
+  })
+  var l []string
+  for i := 0; i < len(found) && i < maxSuggestions; i++ {
+    l = append(l, found[i].route.String())
+  }
+  return l
+}
+
+/**
+ * The maximum edit distance at which a path is considered a near miss
+ */
+func maxEditDistance(p string) int {
+  if d := len(p) / 4; d > 2 {
+    return d
+  }
+  return 2
+}
+
+/**
+ * Compute the Levenshtein distance between two strings
+ */
+func editDistance(a, b string) int {
+  prev := make([]int, len(b) + 1)
+  curr := make([]int, len(b) + 1)
+  for j := range prev {
+    prev[j] = j
+  }
+  for i := 1; i <= len(a); i++ {
+    curr[0] = i
+    for j := 1; j <= len(b); j++ {
+      c := prev[j-1]
+      if a[i-1] != b[j-1] {
+        c++
+      }
+      if x := prev[j] + 1; x < c {
+        c = x
+      }
+      if x := curr[j-1] + 1; x < c {
+        c = x
+      }
+      curr[j] = c
+    }
+    prev, curr = curr, prev
+  }
+  return prev[len(b)]
+}
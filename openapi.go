@@ -0,0 +1,679 @@
+package rest
+
+import (
+  "fmt"
+  "sync"
+  "reflect"
+  "strconv"
+  "strings"
+  "io/ioutil"
+  "net/http"
+  "encoding/json"
+)
+
+import (
+  "github.com/gorilla/mux"
+)
+
+/**
+ * Route metadata attributes, used to describe routes
+ */
+const (
+  ATTR_SUMMARY        = "rest.summary"        // a string summarizing the operation
+  ATTR_DESCRIPTION    = "rest.description"    // a string describing the operation
+  ATTR_OPERATION_ID   = "rest.operation_id"   // a string uniquely identifying the operation
+  ATTR_TAGS           = "rest.tags"           // a []string of tags used to group operations
+  ATTR_REQUEST_TYPE   = "rest.request_type"   // a reflect.Type or a value of the type the route consumes
+  ATTR_RESPONSE_TYPE  = "rest.response_type"  // a reflect.Type or a value of the type the route produces
+  ATTR_ERRORS         = "rest.errors"         // an []int of error statuses the route may respond with
+  ATTR_AUTH_SCHEME    = "rest.auth_scheme"    // the name of the security scheme the route requires
+  ATTR_HIDDEN         = "rest.hidden"         // a bool which, when true, excludes the route from descriptions
+)
+
+/**
+ * The OpenAPI version we produce
+ */
+const openAPIVersion = "3.1.0"
+
+/**
+ * An OpenAPI document
+ */
+type OpenAPI struct {
+  OpenAPI     string                `json:"openapi"`
+  Info        OpenAPIInfo           `json:"info"`
+  Servers     []*OpenAPIServer      `json:"servers,omitempty"`
+  Paths       map[string]*PathItem  `json:"paths"`
+  Components  *Components           `json:"components,omitempty"`
+  Security    []SecurityRequirement `json:"security,omitempty"`
+}
+
+/**
+ * Document information
+ */
+type OpenAPIInfo struct {
+  Title       string  `json:"title"`
+  Version     string  `json:"version"`
+  Description string  `json:"description,omitempty"`
+}
+
+/**
+ * A server
+ */
+type OpenAPIServer struct {
+  URL         string  `json:"url"`
+  Description string  `json:"description,omitempty"`
+}
+
+/**
+ * Reusable components
+ */
+type Components struct {
+  Schemas         map[string]*Schema          `json:"schemas,omitempty"`
+  Parameters      map[string]*Parameter       `json:"parameters,omitempty"`
+  RequestBodies   map[string]*RequestBody     `json:"requestBodies,omitempty"`
+  Responses       map[string]*Response        `json:"responses,omitempty"`
+  SecuritySchemes map[string]*SecurityScheme  `json:"securitySchemes,omitempty"`
+}
+
+/**
+ * A security requirement maps security scheme names to required scopes
+ */
+type SecurityRequirement map[string][]string
+
+/**
+ * A security scheme
+ */
+type SecurityScheme struct {
+  Type          string  `json:"type"`
+  Description   string  `json:"description,omitempty"`
+  Name          string  `json:"name,omitempty"`
+  In            string  `json:"in,omitempty"`
+  Scheme        string  `json:"scheme,omitempty"`
+  BearerFormat  string  `json:"bearerFormat,omitempty"`
+}
+
+/**
+ * The operations available on a path
+ */
+type PathItem struct {
+  Summary     string        `json:"summary,omitempty"`
+  Description string        `json:"description,omitempty"`
+  Get         *Operation    `json:"get,omitempty"`
+  Put         *Operation    `json:"put,omitempty"`
+  Post        *Operation    `json:"post,omitempty"`
+  Delete      *Operation    `json:"delete,omitempty"`
+  Options     *Operation    `json:"options,omitempty"`
+  Head        *Operation    `json:"head,omitempty"`
+  Patch       *Operation    `json:"patch,omitempty"`
+  Trace       *Operation    `json:"trace,omitempty"`
+  Parameters  []*Parameter  `json:"parameters,omitempty"`
+}
+
+/**
+ * Obtain the operations on a path by method
+ */
+func (p *PathItem) Operations() map[string]*Operation {
+  ops := make(map[string]*Operation)
+  for m, e := range map[string]*Operation{"GET":p.Get, "PUT":p.Put, "POST":p.Post, "DELETE":p.Delete, "OPTIONS":p.Options, "HEAD":p.Head, "PATCH":p.Patch, "TRACE":p.Trace} {
+    if e != nil {
+      ops[m] = e
+    }
+  }
+  return ops
+}
+
+/**
+ * Set the operation for a method
+ */
+func (p *PathItem) SetOperation(m string, o *Operation) {
+  switch strings.ToUpper(m) {
+    case "GET":
+      p.Get = o
+    case "PUT":
+      p.Put = o
+    case "POST":
+      p.Post = o
+    case "DELETE":
+      p.Delete = o
+    case "OPTIONS":
+      p.Options = o
+    case "HEAD":
+      p.Head = o
+    case "PATCH":
+      p.Patch = o
+    case "TRACE":
+      p.Trace = o
+  }
+}
+
+/**
+ * An operation
+ */
+type Operation struct {
+  OperationId string                `json:"operationId,omitempty"`
+  Summary     string                `json:"summary,omitempty"`
+  Description string                `json:"description,omitempty"`
+  Tags        []string              `json:"tags,omitempty"`
+  Parameters  []*Parameter          `json:"parameters,omitempty"`
+  RequestBody *RequestBody          `json:"requestBody,omitempty"`
+  Responses   map[string]*Response  `json:"responses"`
+  Security    []SecurityRequirement `json:"security,omitempty"`
+  Deprecated  bool                  `json:"deprecated,omitempty"`
+}
+
+/**
+ * A parameter, or a header when it describes a response header
+ */
+type Parameter struct {
+  Ref         string              `json:"$ref,omitempty"`
+  Name        string              `json:"name,omitempty"`
+  In          string              `json:"in,omitempty"`
+  Description string              `json:"description,omitempty"`
+  Required    bool                `json:"required,omitempty"`
+  Schema      *Schema             `json:"schema,omitempty"`
+  Example     interface{}         `json:"example,omitempty"`
+  Examples    map[string]*Example `json:"examples,omitempty"`
+}
+
+/**
+ * A request body
+ */
+type RequestBody struct {
+  Ref         string                `json:"$ref,omitempty"`
+  Description string                `json:"description,omitempty"`
+  Required    bool                  `json:"required,omitempty"`
+  Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+/**
+ * A response
+ */
+type Response struct {
+  Ref         string                `json:"$ref,omitempty"`
+  Description string                `json:"description"`
+  Headers     map[string]*Parameter `json:"headers,omitempty"`
+  Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+/**
+ * Content of a particular media type
+ */
+type MediaType struct {
+  Schema    *Schema             `json:"schema,omitempty"`
+  Example   interface{}         `json:"example,omitempty"`
+  Examples  map[string]*Example `json:"examples,omitempty"`
+}
+
+/**
+ * An example
+ */
+type Example struct {
+  Ref         string      `json:"$ref,omitempty"`
+  Summary     string      `json:"summary,omitempty"`
+  Description string      `json:"description,omitempty"`
+  Value       interface{} `json:"value,omitempty"`
+}
+
+/**
+ * OpenAPI generation configuration
+ */
+type OpenAPIConfig struct {
+  Info            OpenAPIInfo
+  Servers         []*OpenAPIServer
+  SecuritySchemes map[string]*SecurityScheme
+}
+
+/**
+ * Generate an OpenAPI document describing the service's routes. Routes are
+ * described by the metadata attributes they are registered with and by the
+ * types consumed and produced by typed handlers. Only routes with a path
+ * template and method matchers can be described; other routes and routes
+ * marked hidden are omitted.
+ */
+func (s *Service) OpenAPI(c OpenAPIConfig) (*OpenAPI, error) {
+  doc := &OpenAPI{
+    OpenAPI: openAPIVersion,
+    Info: c.Info,
+    Servers: c.Servers,
+    Paths: make(map[string]*PathItem),
+  }
+  if doc.Info.Title == "" {
+    doc.Info.Title = s.name
+  }
+  if doc.Info.Version == "" {
+    doc.Info.Version = "1.0.0"
+  }
+
+  g := newSchemaGenerator()
+  err := s.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+    if route.GetHandler() == nil {
+      return nil
+    }
+    tmpl, err := route.GetPathTemplate()
+    if err != nil {
+      return nil // no path, this route can't be described
+    }
+    methods, err := route.GetMethods()
+    if err != nil || len(methods) == 0 {
+      return nil
+    }
+    info := s.routes[route]
+    if info != nil {
+      if v, _ := info.attrs[ATTR_HIDDEN].(bool); v {
+        return nil
+      }
+    }
+    path, patterns := openAPIPath(tmpl)
+    item, ok := doc.Paths[path]
+    if !ok {
+      item = &PathItem{}
+      doc.Paths[path] = item
+    }
+    for _, m := range methods {
+      item.SetOperation(m, openAPIOperation(g, info, m, path, patterns))
+    }
+    return nil
+  })
+  if err != nil {
+    return nil, err
+  }
+
+  g.components["Error"] = &Schema{
+    Type: SchemaType{"object"},
+    Properties: map[string]*Schema{
+      "status": &Schema{Type:SchemaType{"integer"}},
+      "message": &Schema{Type:SchemaType{"string"}},
+      "detail": &Schema{},
+    },
+    Required: []string{"status", "message"},
+  }
+  doc.Components = &Components{Schemas:g.components, SecuritySchemes:c.SecuritySchemes}
+
+  return doc, nil
+}
+
+/**
+ * Write the OpenAPI document describing the service to a file. This is
+ * intended to be used at build time, after routes have been registered.
+ */
+func (s *Service) WriteOpenAPI(path string, c OpenAPIConfig) error {
+  doc, err := s.OpenAPI(c)
+  if err != nil {
+    return err
+  }
+  data, err := json.MarshalIndent(doc, "", "  ")
+  if err != nil {
+    return err
+  }
+  return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+/**
+ * Produce a handler which responds with the OpenAPI document describing the
+ * service. The document is generated on the first request, so routes may be
+ * registered after the handler is.
+ */
+func (s *Service) OpenAPIHandler(c OpenAPIConfig) Handler {
+  var once sync.Once
+  var data json.RawMessage
+  var err error
+  return HandlerFunc(func(rsp http.ResponseWriter, req *Request, pln Pipeline) (interface{}, error) {
+    once.Do(func() {
+      var doc *OpenAPI
+      doc, err = s.OpenAPI(c)
+      if err == nil {
+        data, err = json.Marshal(doc)
+      }
+    })
+    if err != nil {
+      return nil, NewErrorf(http.StatusInternalServerError, "Could not generate OpenAPI document: %v", err)
+    }
+    return data, nil
+  })
+}
+
+/**
+ * Serve the OpenAPI document describing the service at /openapi.json
+ */
+func (s *Service) ServeOpenAPI(c OpenAPIConfig) *mux.Route {
+  return s.Context().Handle("/openapi.json", s.OpenAPIHandler(c), Attrs{ATTR_HIDDEN: true}).Methods("GET")
+}
+
+/**
+ * Produce an operation
+ */
+func openAPIOperation(g *schemaGenerator, info *routeInfo, method, path string, patterns map[string]string) *Operation {
+  op := &Operation{Responses:make(map[string]*Response)}
+
+  var attrs Attrs
+  var in, out reflect.Type
+  if info != nil {
+    attrs = info.attrs
+    in, out = info.input, info.output
+  }
+  op.Summary, _ = attrs[ATTR_SUMMARY].(string)
+  op.Description, _ = attrs[ATTR_DESCRIPTION].(string)
+  op.OperationId, _ = attrs[ATTR_OPERATION_ID].(string)
+  op.Tags, _ = attrs[ATTR_TAGS].([]string)
+  if t := typeAttr(attrs, ATTR_REQUEST_TYPE); t != nil {
+    in = t
+  }
+  if t := typeAttr(attrs, ATTR_RESPONSE_TYPE); t != nil {
+    out = t
+  }
+
+  declared := make(map[string]bool)
+  st := in
+  for st != nil && st.Kind() == reflect.Ptr {
+    st = st.Elem()
+  }
+  if st != nil && st.Kind() == reflect.Struct {
+    op.Parameters = openAPIParameters(g, st, declared)
+  }
+  for _, v := range pathVariables(path) {
+    if !declared["path:"+ v] {
+      p := &Parameter{Name:v, In:"path", Required:true, Schema:&Schema{Type:SchemaType{"string"}}}
+      if r, ok := patterns[v]; ok {
+        p.Schema.Pattern = "^"+ r +"$"
+      }
+      op.Parameters = append(op.Parameters, p)
+    }
+  }
+
+  if in != nil && method != "GET" && method != "HEAD" {
+    if st != nil && st.Kind() == reflect.Struct {
+      if hasEntityFields(st) {
+        op.RequestBody = &RequestBody{Required:true, Content:map[string]*MediaType{CONTENT_TYPE_JSON: &MediaType{Schema:g.schema(st)}}}
+      }
+    }else{
+      op.RequestBody = &RequestBody{Required:true, Content:map[string]*MediaType{CONTENT_TYPE_JSON: &MediaType{Schema:g.schema(in)}}}
+    }
+  }
+
+  ok := &Response{Description:http.StatusText(http.StatusOK)}
+  if out != nil {
+    if out.Implements(reflect.TypeOf((*Entity)(nil)).Elem()) {
+      ok.Content = map[string]*MediaType{"application/octet-stream": &MediaType{Schema:&Schema{Type:SchemaType{"string"}, Format:"binary"}}}
+    }else{
+      ok.Content = map[string]*MediaType{CONTENT_TYPE_JSON: &MediaType{Schema:g.schema(out)}}
+    }
+  }
+  op.Responses[strconv.Itoa(http.StatusOK)] = ok
+
+  var errs []int
+  if in != nil {
+    errs = append(errs, http.StatusBadRequest, http.StatusUnprocessableEntity)
+  }
+  if v, ok := attrs[ATTR_ERRORS].([]int); ok {
+    errs = append(errs, v...)
+  }
+  for _, e := range errs {
+    op.Responses[strconv.Itoa(e)] = &Response{
+      Description: http.StatusText(e),
+      Content: map[string]*MediaType{CONTENT_TYPE_JSON: &MediaType{Schema:&Schema{Ref:"#/components/schemas/Error"}}},
+    }
+  }
+
+  if v, ok := attrs[ATTR_AUTH_SCHEME].(string); ok && v != "" {
+    op.Security = []SecurityRequirement{{v: []string{}}}
+  }
+
+  return op
+}
+
+/**
+ * Produce parameters for fields bound from path variables, query parameters
+ * and headers
+ */
+func openAPIParameters(g *schemaGenerator, t reflect.Type, declared map[string]bool) []*Parameter {
+  var params []*Parameter
+  for i := 0; i < t.NumField(); i++ {
+    f := t.Field(i)
+    if f.PkgPath != "" && !f.Anonymous {
+      continue
+    }
+    if f.Anonymous && f.Type.Kind() == reflect.Struct && !hasAnyTag(f) {
+      params = append(params, openAPIParameters(g, f.Type, declared)...)
+      continue
+    }
+    for _, src := range []string{"path", "query", "header"} {
+      n := f.Tag.Get(src)
+      if n == "" || n == "-" {
+        continue
+      }
+      p := &Parameter{Name:n, In:src, Required:src == "path" || fieldRequired(f), Schema:g.schema(f.Type)}
+      applyValidation(p.Schema, f)
+      if d, ok := f.Tag.Lookup("default"); ok && p.Schema.Ref == "" {
+        p.Schema.Default = d
+      }
+      params = append(params, p)
+      declared[src +":"+ n] = true
+      break
+    }
+  }
+  return params
+}
+
+/**
+ * Obtain a type from an attribute, which may be a reflect.Type or a value
+ */
+func typeAttr(a Attrs, k string) reflect.Type {
+  switch v := a[k].(type) {
+    case nil:
+      return nil
+    case reflect.Type:
+      return v
+    default:
+      return reflect.TypeOf(v)
+  }
+}
+
+/**
+ * Convert a route path template to an OpenAPI path. Variable patterns are
+ * removed from the path and returned.
+ */
+func openAPIPath(tmpl string) (string, map[string]string) {
+  var path strings.Builder
+  patterns := make(map[string]string)
+  for i := 0; i < len(tmpl); i++ {
+    if tmpl[i] != '{' {
+      path.WriteByte(tmpl[i])
+      continue
+    }
+    depth, j := 1, i + 1
+    for ; j < len(tmpl) && depth > 0; j++ {
+      switch tmpl[j] {
+        case '{':
+          depth++
+        case '}':
+          depth--
+      }
+    }
+    v := tmpl[i+1:j-1]
+    if x := strings.Index(v, ":"); x >= 0 {
+      patterns[v[:x]] = v[x+1:]
+      v = v[:x]
+    }
+    path.WriteString("{"+ v +"}")
+    i = j - 1
+  }
+  return path.String(), patterns
+}
+
+/**
+ * Obtain the variables in an OpenAPI path
+ */
+func pathVariables(path string) []string {
+  var vars []string
+  for {
+    x := strings.Index(path, "{")
+    if x < 0 {
+      break
+    }
+    y := strings.Index(path[x:], "}")
+    if y < 0 {
+      break
+    }
+    vars = append(vars, path[x+1:x+y])
+    path = path[x+y+1:]
+  }
+  return vars
+}
+
+/**
+ * Load an OpenAPI document from a file. Only JSON documents are supported.
+ */
+func LoadOpenAPI(path string) (*OpenAPI, error) {
+  data, err := ioutil.ReadFile(path)
+  if err != nil {
+    return nil, err
+  }
+  return ParseOpenAPI(data)
+}
+
+/**
+ * Parse an OpenAPI document. Only JSON documents are supported.
+ */
+func ParseOpenAPI(data []byte) (*OpenAPI, error) {
+  doc := &OpenAPI{}
+  err := json.Unmarshal(data, doc)
+  if err != nil {
+    return nil, fmt.Errorf("Could not parse OpenAPI document (only JSON is supported): %v", err)
+  }
+  if doc.Paths == nil {
+    doc.Paths = make(map[string]*PathItem)
+  }
+  if doc.Components == nil {
+    doc.Components = &Components{}
+  }
+  return doc, nil
+}
+
+/**
+ * The maximum number of references followed when resolving a component
+ */
+const maxRefDepth = 32
+
+/**
+ * Obtain the name of a local component reference of the specified kind
+ */
+func componentRef(ref, kind string) (string, bool) {
+  p := "#/components/"+ kind +"/"
+  if !strings.HasPrefix(ref, p) {
+    return "", false
+  }
+  return ref[len(p):], true
+}
+
+/**
+ * Resolve a schema reference. Only local component references can be
+ * resolved; if a reference cannot be resolved nil is returned.
+ */
+func (d *OpenAPI) ResolveSchema(s *Schema) *Schema {
+  for i := 0; s != nil && s.Ref != "" && i < maxRefDepth; i++ {
+    n, ok := componentRef(s.Ref, "schemas")
+    if !ok || d.Components == nil {
+      return nil
+    }
+    s = d.Components.Schemas[n]
+  }
+  if s != nil && s.Ref != "" {
+    return nil
+  }
+  return s
+}
+
+/**
+ * Resolve a parameter reference
+ */
+func (d *OpenAPI) ResolveParameter(p *Parameter) *Parameter {
+  for i := 0; p != nil && p.Ref != "" && i < maxRefDepth; i++ {
+    n, ok := componentRef(p.Ref, "parameters")
+    if !ok || d.Components == nil {
+      return nil
+    }
+    p = d.Components.Parameters[n]
+  }
+  if p != nil && p.Ref != "" {
+    return nil
+  }
+  return p
+}
+
+/**
+ * Resolve a request body reference
+ */
+func (d *OpenAPI) ResolveRequestBody(b *RequestBody) *RequestBody {
+  for i := 0; b != nil && b.Ref != "" && i < maxRefDepth; i++ {
+    n, ok := componentRef(b.Ref, "requestBodies")
+    if !ok || d.Components == nil {
+      return nil
+    }
+    b = d.Components.RequestBodies[n]
+  }
+  if b != nil && b.Ref != "" {
+    return nil
+  }
+  return b
+}
+
+/**
+ * Resolve a response reference
+ */
+func (d *OpenAPI) ResolveResponse(r *Response) *Response {
+  for i := 0; r != nil && r.Ref != "" && i < maxRefDepth; i++ {
+    n, ok := componentRef(r.Ref, "responses")
+    if !ok || d.Components == nil {
+      return nil
+    }
+    r = d.Components.Responses[n]
+  }
+  if r != nil && r.Ref != "" {
+    return nil
+  }
+  return r
+}
+
+/**
+ * Obtain the parameters in effect for an operation on a path, with path
+ * level parameters overridden by operation parameters of the same name and
+ * location. References are resolved; those that cannot be are omitted.
+ */
+func (d *OpenAPI) OperationParameters(item *PathItem, op *Operation) []*Parameter {
+  var params []*Parameter
+  index := make(map[string]int)
+  for _, l := range [][]*Parameter{item.Parameters, op.Parameters} {
+    for _, e := range l {
+      p := d.ResolveParameter(e)
+      if p == nil {
+        continue
+      }
+      k := p.In +":"+ p.Name
+      if x, ok := index[k]; ok {
+        params[x] = p
+      }else{
+        index[k] = len(params)
+        params = append(params, p)
+      }
+    }
+  }
+  return params
+}
+
+/**
+ * Obtain the response for a status from a set of responses, considering
+ * exact statuses, then ranges like 2XX, then the default response
+ */
+func (d *OpenAPI) ResponseForStatus(responses map[string]*Response, status int) *Response {
+  s := strconv.Itoa(status)
+  if r, ok := responses[s]; ok {
+    return d.ResolveResponse(r)
+  }
+  if r, ok := responses[s[:1] +"XX"]; ok {
+    return d.ResolveResponse(r)
+  }
+  if r, ok := responses["default"]; ok {
+    return d.ResolveResponse(r)
+  }
+  return nil
+}
@@ -4,6 +4,7 @@ import (
   "fmt"
   "time"
   "strings"
+  "context"
   "net/http"
   "encoding/base64"
 )
@@ -29,6 +30,31 @@ func mergeAttrs(a ...Attrs) Attrs {
   return m
 }
 
+/**
+ * Obtain an integer attribute
+ */
+func intAttr(a Attrs, k string) (int64, bool) {
+  if a == nil {
+    return 0, false
+  }
+  switch v := a[k].(type) {
+    case int:
+      return int64(v), true
+    case int32:
+      return int64(v), true
+    case int64:
+      return v, true
+    case uint:
+      return int64(v), true
+    case uint32:
+      return int64(v), true
+    case uint64:
+      return int64(v), true
+    default:
+      return 0, false
+  }
+}
+
 /**
  * Internal request flags
  */
@@ -43,25 +69,46 @@ const (
  */
 type Request struct {
   *http.Request
-  Id    string
-  Attrs Attrs
-  flags requestFlags
-  start time.Time
+  Id        string
+  Attrs     Attrs
+  Principal *Principal // the authenticated principal, if any
+  flags     requestFlags
+  start     time.Time
+  service   *Service
+  digest    *entityDigest // the digest of a content-coded entity, as it is read
 }
 
 /**
  * Create a service request
  */
-func newRequest(r *http.Request) *Request {
-  return newRequestWithAttributes(r, nil)
+func newRequest(s *Service, r *http.Request) *Request {
+  return newRequestWithAttributes(s, r, nil)
 }
 
 /**
  * Create a service request
  */
-func newRequestWithAttributes(r *http.Request, a Attrs) *Request {
+func newRequestWithAttributes(s *Service, r *http.Request, a Attrs) *Request {
   id := TimeUUID()
-  return &Request{r, base64.RawURLEncoding.EncodeToString(id[:]), a, 0, time.Now()}
+  return &Request{Request:r, Id:base64.RawURLEncoding.EncodeToString(id[:]), Attrs:a, start:time.Now(), service:s}
+}
+
+/**
+ * Copy the request with a new context, for example to continue handling it
+ * after the original request has completed. The copy belongs to the same
+ * service and has the same identifier and principal, and a copy of the
+ * attributes. The entity is shared with the original request, so a copy
+ * which outlives it should replace the body.
+ */
+func (r *Request) Clone(cxt context.Context) *Request {
+  var attrs Attrs
+  if r.Attrs != nil {
+    attrs = make(Attrs)
+    for k, v := range r.Attrs {
+      attrs[k] = v
+    }
+  }
+  return &Request{Request:r.Request.Clone(cxt), Id:r.Id, Attrs:attrs, Principal:r.Principal, start:time.Now(), service:r.service}
 }
 
 /**
@@ -84,6 +131,13 @@ func (r *Request) Finalize() {
   r.flags |= reqFlagFinalized
 }
 
+/**
+ * Determine if the request has been finalized
+ */
+func (r *Request) Finalized() bool {
+  return (r.flags & reqFlagFinalized) == reqFlagFinalized
+}
+
 /**
  * Obtain the start / creation time of the request
  */
@@ -146,8 +200,8 @@ func (p Pipeline) Add(h Handler) Pipeline {
  * Continue processing the pipeline
  */
 func (p Pipeline) Next(w http.ResponseWriter, r *Request) (interface{}, error) {
-  if len(p) < 0 {
-    return nil, nil // empty pipline
+  if len(p) == 0 {
+    return nil, nil // empty pipeline
   }else{
     return p[0].ServeRequest(w, r, p[1:])
   }
@@ -0,0 +1,44 @@
+package rest
+
+import (
+  "time"
+)
+
+/**
+ * An authenticated principal: the user, service or key on whose behalf a
+ * request is made
+ */
+type Principal struct {
+  Subject string                  `json:"subject"`
+  Scheme  string                  `json:"scheme"` // the scheme by which the principal was authenticated
+  Roles   []string                `json:"roles,omitempty"`
+  Scopes  []string                `json:"scopes,omitempty"`
+  Claims  map[string]interface{}  `json:"claims,omitempty"`
+  Expires time.Time               `json:"-"` // when the credentials expire, if they do
+}
+
+/**
+ * Determine if the principal has a role
+ */
+func (p *Principal) HasRole(r string) bool {
+  return p != nil && contains(p.Roles, r)
+}
+
+/**
+ * Determine if the principal has a scope
+ */
+func (p *Principal) HasScope(s string) bool {
+  return p != nil && contains(p.Scopes, s)
+}
+
+/**
+ * Determine if a set contains a value
+ */
+func contains(set []string, v string) bool {
+  for _, e := range set {
+    if e == v {
+      return true
+    }
+  }
+  return false
+}
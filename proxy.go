@@ -0,0 +1,260 @@
+package rest
+
+import (
+  "fmt"
+  "net"
+  "strings"
+)
+
+/**
+ * Parse networks in CIDR notation; a bare address is taken to be a network
+ * containing only that address
+ */
+func ParseNetworks(s ...string) ([]*net.IPNet, error) {
+  var n []*net.IPNet
+  for _, e := range s {
+    e = strings.TrimSpace(e)
+    if e == "" {
+      continue
+    }
+    if !strings.Contains(e, "/") {
+      ip := net.ParseIP(e)
+      if ip == nil {
+        return nil, fmt.Errorf("Invalid address: %v", e)
+      }
+      if v4 := ip.To4(); v4 != nil {
+        n = append(n, &net.IPNet{IP:v4, Mask:net.CIDRMask(32, 32)})
+      }else{
+        n = append(n, &net.IPNet{IP:ip, Mask:net.CIDRMask(128, 128)})
+      }
+      continue
+    }
+    _, x, err := net.ParseCIDR(e)
+    if err != nil {
+      return nil, fmt.Errorf("Invalid network: %v", e)
+    }
+    n = append(n, x)
+  }
+  return n, nil
+}
+
+/**
+ * Determine if an address is in any of a set of networks
+ */
+func containsIP(n []*net.IPNet, ip net.IP) bool {
+  if ip == nil {
+    return false
+  }
+  for _, e := range n {
+    if e.Contains(ip) {
+      return true
+    }
+  }
+  return false
+}
+
+/**
+ * Determine if an address is that of a trusted proxy
+ */
+func (s *Service) trustsProxy(ip net.IP) bool {
+  return s != nil && containsIP(s.trustedProxies, ip)
+}
+
+/**
+ * Obtain the address of the peer which made the request, which may be a
+ * proxy acting on behalf of the client
+ */
+func (r *Request) PeerIP() net.IP {
+  return parseHostIP(r.RemoteAddr)
+}
+
+/**
+ * Obtain the address of the client which made the request. When the peer
+ * is a trusted proxy the addresses it forwards, in the header the service
+ * is configured to honor, are examined from right to left and the first
+ * which is not a trusted proxy is the client. Other forwarding headers are
+ * ignored, since a proxy which does not set them passes along whatever the
+ * client sent. The request's RemoteAddr is never modified.
+ */
+func (r *Request) ClientIP() net.IP {
+  peer := r.PeerIP()
+  if !r.service.trustsProxy(peer) {
+    return peer
+  }
+  var hops []string
+  if r.service.forwardedHeader == "Forwarded" {
+    for _, e := range forwardedElements(strings.Join(r.Header.Values("Forwarded"), ",")) {
+      hops = append(hops, e["for"])
+    }
+  }else{
+    hops = listValues(r.Header.Values(r.service.forwardedHeader))
+  }
+  client, _ := r.service.walkHops(peer, hops)
+  return client
+}
+
+/**
+ * Walk forwarded hops from right to left, beginning with the hop added by
+ * the peer, until one is found which is not a trusted proxy. The result is
+ * the client address and the index of the last hop examined, which was
+ * added by a trusted proxy, or -1 if there are no hops.
+ */
+func (s *Service) walkHops(peer net.IP, hops []string) (net.IP, int) {
+  client, at := peer, -1
+  for i := len(hops) - 1; i >= 0; i-- {
+    at = i
+    ip := parseHostIP(hops[i])
+    if ip == nil {
+      break // obfuscated or invalid; the last trusted hop is as far as we can see
+    }
+    client = ip
+    if !s.trustsProxy(ip) {
+      break
+    }
+  }
+  return client, at
+}
+
+/**
+ * Obtain the port the client addressed with the request
+ */
+func (r *Request) ExternalPort() string {
+  if p := r.forwardedParam("", "X-Forwarded-Port"); p != "" {
+    return p
+  }
+  if _, p, err := net.SplitHostPort(r.ExternalHost()); err == nil && p != "" {
+    return p
+  }
+  if r.Scheme() == "https" {
+    return "443"
+  }
+  return "80"
+}
+
+/**
+ * Parse an address which may include a port and may be bracketed or quoted,
+ * as addresses in Forwarded headers are
+ */
+func parseHostIP(s string) net.IP {
+  s = strings.Trim(strings.TrimSpace(s), `"`)
+  if h, _, err := net.SplitHostPort(s); err == nil {
+    s = h
+  }
+  return net.ParseIP(strings.Trim(s, "[]"))
+}
+
+/**
+ * Parse the elements of an RFC 7239 Forwarded header into their parameters.
+ * Parameter names are lowercased and quoted values are unquoted; commas and
+ * semicolons within quoted values do not separate elements or parameters.
+ * If an element repeats a parameter the last occurrence is used.
+ */
+func forwardedElements(h string) []map[string]string {
+  var elems []map[string]string
+  for _, e := range splitQuoted(h, ',') {
+    m := make(map[string]string)
+    for _, p := range splitQuoted(e, ';') {
+      if x := strings.Index(p, "="); x > 0 {
+        m[strings.ToLower(strings.TrimSpace(p[:x]))] = unquote(strings.TrimSpace(p[x+1:]))
+      }
+    }
+    elems = append(elems, m)
+  }
+  return elems
+}
+
+/**
+ * Split a header value on a separator which does not appear within a
+ * quoted string
+ */
+func splitQuoted(s string, sep byte) []string {
+  var l []string
+  quoted, start := false, 0
+  for i := 0; i < len(s); i++ {
+    switch c := s[i]; {
+      case quoted && c == '\\':
+        i++ // skip the escaped character
+      case c == '"':
+        quoted = !quoted
+      case !quoted && c == sep:
+        l = append(l, s[start:i])
+        start = i + 1
+    }
+  }
+  return append(l, s[start:])
+}
+
+/**
+ * Unquote a quoted string, removing escapes; other values are returned
+ * as-is
+ */
+func unquote(s string) string {
+  if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+    return s
+  }
+  var b strings.Builder
+  s = s[1:len(s)-1]
+  for i := 0; i < len(s); i++ {
+    if s[i] == '\\' && i + 1 < len(s) {
+      i++
+    }
+    b.WriteByte(s[i])
+  }
+  return b.String()
+}
+
+/**
+ * Split comma-separated header values into a list
+ */
+func listValues(h []string) []string {
+  var l []string
+  for _, e := range strings.Split(strings.Join(h, ","), ",") {
+    if e = strings.TrimSpace(e); e != "" {
+      l = append(l, e)
+    }
+  }
+  return l
+}
+
+/**
+ * Obtain a parameter of the request as the client made it, as forwarded by
+ * a trusted proxy, or the empty string if the peer is not trusted or does
+ * not provide it.
+ *
+ * If the service honors the Forwarded header the parameter is taken from
+ * the element added by the outermost trusted proxy: the one found by
+ * walking the elements from right to left, as for the client address,
+ * whose for parameter is not a trusted proxy. Elements to its left were
+ * provided by the client or an untrusted proxy. Values are unquoted, and if
+ * that element repeats the parameter the last occurrence is used. An empty
+ * param names a parameter Forwarded does not carry, so nothing is returned.
+ *
+ * Otherwise the parameter is taken from the named X-Forwarded header. These
+ * headers don't identify the hop that added each value, so only the
+ * rightmost value, which was added by the peer, is used; repeated headers
+ * are joined as a comma-separated list first. These values are not quoted.
+ */
+func (r *Request) forwardedParam(param, header string) string {
+  peer := r.PeerIP()
+  if !r.service.trustsProxy(peer) {
+    return ""
+  }
+  if r.service.forwardedHeader != "Forwarded" {
+    if l := listValues(r.Header.Values(header)); len(l) > 0 {
+      return l[len(l)-1]
+    }
+    return ""
+  }
+  if param == "" {
+    return ""
+  }
+  elems := forwardedElements(strings.Join(r.Header.Values("Forwarded"), ","))
+  hops := make([]string, len(elems))
+  for i, e := range elems {
+    hops[i] = e["for"]
+  }
+  if _, at := r.service.walkHops(peer, hops); at >= 0 {
+    return elems[at][param]
+  }
+  return ""
+}
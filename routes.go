@@ -0,0 +1,404 @@
+package rest
+
+import (
+  "fmt"
+  "regexp"
+  "reflect"
+  "runtime"
+  "strings"
+  "net/http"
+  "encoding/json"
+)
+
+import (
+  "github.com/gorilla/mux"
+)
+
+/**
+ * A route descriptor
+ */
+type Route struct {
+  Template  string    `json:"template,omitempty"`
+  Prefix    bool      `json:"prefix,omitempty"` // the template matches any path it prefixes
+  Host      string    `json:"host,omitempty"`
+  Methods   []string  `json:"methods,omitempty"`
+  Queries   []string  `json:"queries,omitempty"`
+  Name      string    `json:"name,omitempty"`
+  Attrs     Attrs     `json:"attrs,omitempty"`
+  Pipeline  []string  `json:"pipeline,omitempty"`
+  Input     string    `json:"input,omitempty"`
+  Output    string    `json:"output,omitempty"`
+}
+
+/**
+ * Describe a route
+ */
+func (r Route) String() string {
+  var s string
+  if len(r.Methods) > 0 {
+    s = strings.Join(r.Methods, ",") +" "
+  }else{
+    s = "* "
+  }
+  s += r.Host + r.Template
+  if r.Prefix {
+    s += "*"
+  }
+  if len(r.Queries) > 0 {
+    s += "?"+ strings.Join(r.Queries, "&")
+  }
+  if r.Name != "" {
+    s += " ("+ r.Name +")"
+  }
+  return s
+}
+
+/**
+ * Obtain descriptors for every route with a handler, in the order routes
+ * are matched
+ */
+func (s *Service) Routes() ([]Route, error) {
+  var routes []Route
+  err := s.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+    if route.GetHandler() == nil {
+      return nil // subrouter prefix
+    }
+    r := Route{Name:route.GetName()}
+    if t, err := route.GetPathTemplate(); err == nil {
+      r.Template = t
+      if x, err := route.GetPathRegexp(); err == nil && !strings.HasSuffix(x, "$") {
+        r.Prefix = true
+      }
+    }
+    if t, err := route.GetHostTemplate(); err == nil {
+      r.Host = t
+    }
+    if m, err := route.GetMethods(); err == nil {
+      r.Methods = m
+    }
+    if q, err := route.GetQueriesTemplates(); err == nil {
+      r.Queries = q
+    }
+    if info, ok := s.routes[route]; ok {
+      r.Attrs = info.attrs
+      r.Pipeline = stageNames(info.handler)
+      if info.input != nil {
+        r.Input = info.input.String()
+      }
+      if info.output != nil {
+        r.Output = info.output.String()
+      }
+    }
+    routes = append(routes, r)
+    return nil
+  })
+  if err != nil {
+    return nil, err
+  }
+  return routes, nil
+}
+
+/**
+ * Obtain the names of the stages in a handler
+ */
+func stageNames(h Handler) []string {
+  if c, ok := h.(*contextHandler); ok {
+    return stageNames(c.pipeline())
+  }
+  if p, ok := h.(Pipeline); ok {
+    var n []string
+    for _, e := range p {
+      n = append(n, stageNames(e)...)
+    }
+    return n
+  }
+  return []string{stageName(h)}
+}
+
+/**
+ * Obtain the name of a handler
+ */
+func stageName(h Handler) string {
+  switch v := h.(type) {
+    case HandlerFunc:
+      if f := runtime.FuncForPC(reflect.ValueOf(v).Pointer()); f != nil {
+        return f.Name()
+      }
+  }
+  return reflect.TypeOf(h).String()
+}
+
+/**
+ * A conflict between two routes. Routes are matched in the order they are
+ * registered, so the later route is the one affected.
+ */
+type RouteConflict struct {
+  Route     Route   `json:"route"`
+  Conflicts Route   `json:"conflicts_with"`
+  Shadowed  bool    `json:"shadowed"` // the route can never be matched, otherwise some requests are ambiguous
+}
+
+/**
+ * Describe the conflict
+ */
+func (c RouteConflict) String() string {
+  if c.Shadowed {
+    return fmt.Sprintf("Route %v is shadowed by %v and can never be matched", c.Route, c.Conflicts)
+  }else{
+    return fmt.Sprintf("Route %v is ambiguous with %v; some requests will only match the latter", c.Route, c.Conflicts)
+  }
+}
+
+/**
+ * Find routes which are shadowed by, or ambiguous with, routes registered
+ * before them. Routes which differ by host or query matchers are not
+ * compared.
+ */
+func (s *Service) CheckRoutes() ([]RouteConflict, error) {
+  routes, err := s.Routes()
+  if err != nil {
+    return nil, err
+  }
+  var conflicts []RouteConflict
+  for i, b := range routes {
+    for _, a := range routes[:i] {
+      if a.Host != b.Host || strings.Join(a.Queries, "&") != strings.Join(b.Queries, "&") || !methodsOverlap(a.Methods, b.Methods) {
+        continue
+      }
+      if covers, overlaps := compareTemplates(a, b); covers && methodsCover(a.Methods, b.Methods) {
+        conflicts = append(conflicts, RouteConflict{b, a, true})
+        break
+      }else if overlaps {
+        conflicts = append(conflicts, RouteConflict{b, a, false})
+      }
+    }
+  }
+  return conflicts, nil
+}
+
+/**
+ * Determine if two method sets overlap; an empty set matches any method
+ */
+func methodsOverlap(a, b []string) bool {
+  if len(a) == 0 || len(b) == 0 {
+    return true
+  }
+  for _, e := range a {
+    for _, f := range b {
+      if strings.EqualFold(e, f) {
+        return true
+      }
+    }
+  }
+  return false
+}
+
+/**
+ * Determine if method set a includes every method in b
+ */
+func methodsCover(a, b []string) bool {
+  if len(a) == 0 {
+    return true
+  }
+  if len(b) == 0 {
+    return false
+  }
+  for _, f := range b {
+    found := false
+    for _, e := range a {
+      if strings.EqualFold(e, f) {
+        found = true
+        break
+      }
+    }
+    if !found {
+      return false
+    }
+  }
+  return true
+}
+
+/**
+ * A path template segment
+ */
+type templateSegment struct {
+  literal string
+  pattern string // the variable pattern, if the segment is a variable
+  isvar   bool
+}
+
+/**
+ * Split a path template into segments
+ */
+func templateSegments(t string) []templateSegment {
+  var segs []templateSegment
+  for _, e := range strings.Split(strings.Trim(t, "/"), "/") {
+    if len(e) > 1 && e[0] == '{' && e[len(e)-1] == '}' {
+      v := e[1:len(e)-1]
+      p := "[^/]+"
+      if x := strings.Index(v, ":"); x >= 0 {
+        p = v[x+1:]
+      }
+      segs = append(segs, templateSegment{pattern:p, isvar:true})
+    }else{
+      segs = append(segs, templateSegment{literal:e})
+    }
+  }
+  return segs
+}
+
+/**
+ * Compare path templates. The first result is true if every path matched by
+ * b is also matched by a; the second is true if some path may be matched
+ * by both. Templates which mix literals and variables within a segment are
+ * compared literally.
+ */
+func compareTemplates(a, b Route) (bool, bool) {
+  if a.Template == "" {
+    return true, true // a matches any path
+  }
+  if b.Template == "" {
+    return false, true
+  }
+  sa, sb := templateSegments(a.Template), templateSegments(b.Template)
+  if a.Prefix {
+    if len(sb) < len(sa) {
+      return false, b.Prefix
+    }
+    sb = sb[:len(sa)]
+  }else if len(sa) != len(sb) {
+    if b.Prefix && len(sb) <= len(sa) {
+      sa = sa[:len(sb)]
+      covers, overlaps := compareSegments(sa, sb)
+      return false, covers || overlaps
+    }
+    return false, false
+  }else if b.Prefix {
+    _, overlaps := compareSegments(sa, sb)
+    return false, overlaps
+  }
+  return compareSegments(sa, sb)
+}
+
+/**
+ * Compare template segments of the same length
+ */
+func compareSegments(sa, sb []templateSegment) (bool, bool) {
+  covers := true
+  for i, x := range sa {
+    y := sb[i]
+    switch {
+      case !x.isvar && !y.isvar:
+        if x.literal != y.literal {
+          return false, false
+        }
+      case x.isvar && !y.isvar:
+        if !patternMatches(x.pattern, y.literal) {
+          return false, false
+        }
+      case !x.isvar && y.isvar:
+        if !patternMatches(y.pattern, x.literal) {
+          return false, false
+        }
+        covers = false
+      default:
+        if x.pattern != "[^/]+" && x.pattern != y.pattern {
+          covers = false
+        }
+    }
+  }
+  return covers, true
+}
+
+/**
+ * Determine if a variable pattern matches a literal segment
+ */
+func patternMatches(p, s string) bool {
+  r, err := regexp.Compile("^(?:"+ p +")$")
+  if err != nil {
+    return true // assume the worst
+  }
+  return r.MatchString(s)
+}
+
+/**
+ * Produce a handler which responds with the service's routes and any
+ * conflicts between them
+ */
+func (s *Service) RoutesHandler() Handler {
+  return HandlerFunc(func(rsp http.ResponseWriter, req *Request, pln Pipeline) (interface{}, error) {
+    routes, err := s.Routes()
+    if err != nil {
+      return nil, NewErrorf(http.StatusInternalServerError, "Could not describe routes: %v", err)
+    }
+    conflicts, err := s.CheckRoutes()
+    if err != nil {
+      return nil, NewErrorf(http.StatusInternalServerError, "Could not check routes: %v", err)
+    }
+    for i, e := range routes {
+      routes[i].Attrs = describeAttrs(e.Attrs)
+    }
+    for i, e := range conflicts {
+      conflicts[i].Route.Attrs = describeAttrs(e.Route.Attrs)
+      conflicts[i].Conflicts.Attrs = describeAttrs(e.Conflicts.Attrs)
+    }
+    return struct {
+      Routes    []Route         `json:"routes"`
+      Conflicts []RouteConflict `json:"conflicts,omitempty"`
+    }{routes, conflicts}, nil
+  })
+}
+
+/**
+ * Serve the service's routes at /routes. This exposes the structure of the
+ * service and is intended for administrative contexts.
+ */
+func (s *Service) ServeRoutes() *mux.Route {
+  return s.Context().Handle("/routes", s.RoutesHandler(), Attrs{ATTR_HIDDEN: true}).Methods("GET")
+}
+
+/**
+ * Produce attributes which can be represented as JSON; values which cannot
+ * be are described instead, and functions are described by name
+ */
+func describeAttrs(a Attrs) Attrs {
+  if a == nil {
+    return nil
+  }
+  d := make(Attrs)
+  for k, v := range a {
+    d[k] = describeValue(v)
+  }
+  return d
+}
+
+/**
+ * Describe an attribute value
+ */
+func describeValue(v interface{}) interface{} {
+  if t, ok := v.(reflect.Type); ok {
+    return t.String()
+  }
+  rv := reflect.ValueOf(v)
+  switch rv.Kind() {
+    case reflect.Func:
+      if rv.IsNil() {
+        return nil
+      }
+      if f := runtime.FuncForPC(rv.Pointer()); f != nil {
+        return f.Name()
+      }
+    case reflect.Slice:
+      if rv.Type().Elem().Kind() == reflect.Func || rv.Type().Elem().Kind() == reflect.Interface {
+        l := make([]interface{}, rv.Len())
+        for i := range l {
+          l[i] = describeValue(rv.Index(i).Interface())
+        }
+        return l
+      }
+  }
+  if _, err := json.Marshal(v); err != nil {
+    return fmt.Sprintf("%v", v)
+  }
+  return v
+}
@@ -0,0 +1,390 @@
+package rest
+
+import (
+  "fmt"
+  "reflect"
+  "strconv"
+  "strings"
+  "encoding/json"
+)
+
+/**
+ * A JSON Schema type, which may be a single type or a list of types
+ */
+type SchemaType []string
+
+/**
+ * Determine if the type includes the specified type
+ */
+func (t SchemaType) Has(n string) bool {
+  for _, e := range t {
+    if e == n {
+      return true
+    }
+  }
+  return false
+}
+
+/**
+ * Marshal; a single type is represented as a string
+ */
+func (t SchemaType) MarshalJSON() ([]byte, error) {
+  if len(t) == 1 {
+    return json.Marshal(t[0])
+  }else{
+    return json.Marshal([]string(t))
+  }
+}
+
+/**
+ * Unmarshal
+ */
+func (t *SchemaType) UnmarshalJSON(data []byte) error {
+  var s string
+  if err := json.Unmarshal(data, &s); err == nil {
+    *t = SchemaType{s}
+    return nil
+  }
+  var l []string
+  if err := json.Unmarshal(data, &l); err != nil {
+    return fmt.Errorf("Invalid schema type: %s", string(data))
+  }
+  *t = SchemaType(l)
+  return nil
+}
+
+/**
+ * A JSON Schema, as used by OpenAPI 3.1. Only the subset of keywords that
+ * are useful to describe and validate API entities is supported.
+ */
+type Schema struct {
+  Ref                   string              `json:"$ref,omitempty"`
+  Type                  SchemaType          `json:"type,omitempty"`
+  Format                string              `json:"format,omitempty"`
+  Title                 string              `json:"title,omitempty"`
+  Description           string              `json:"description,omitempty"`
+  Enum                  []interface{}       `json:"enum,omitempty"`
+  Default               interface{}         `json:"default,omitempty"`
+  Example               interface{}         `json:"example,omitempty"`
+  Examples              []interface{}       `json:"examples,omitempty"`
+  Minimum               *float64            `json:"minimum,omitempty"`
+  Maximum               *float64            `json:"maximum,omitempty"`
+  ExclusiveMinimum      interface{}         `json:"exclusiveMinimum,omitempty"` // a number, or a boolean in OpenAPI 3.0
+  ExclusiveMaximum      interface{}         `json:"exclusiveMaximum,omitempty"` // a number, or a boolean in OpenAPI 3.0
+  MinLength             *int                `json:"minLength,omitempty"`
+  MaxLength             *int                `json:"maxLength,omitempty"`
+  Pattern               string              `json:"pattern,omitempty"`
+  MinItems              *int                `json:"minItems,omitempty"`
+  MaxItems              *int                `json:"maxItems,omitempty"`
+  UniqueItems           bool                `json:"uniqueItems,omitempty"`
+  Items                 *Schema             `json:"items,omitempty"`
+  Properties            map[string]*Schema  `json:"properties,omitempty"`
+  Required              []string            `json:"required,omitempty"`
+  AdditionalProperties  *Schema             `json:"-"`
+  NoAdditional          bool                `json:"-"` // additionalProperties is false
+  AllOf                 []*Schema           `json:"allOf,omitempty"`
+  AnyOf                 []*Schema           `json:"anyOf,omitempty"`
+  OneOf                 []*Schema           `json:"oneOf,omitempty"`
+  Not                   *Schema             `json:"not,omitempty"`
+  Nullable              bool                `json:"nullable,omitempty"` // OpenAPI 3.0 only
+  ReadOnly              bool                `json:"readOnly,omitempty"`
+  WriteOnly             bool                `json:"writeOnly,omitempty"`
+}
+
+/**
+ * Schema fields, without our marshaling methods
+ */
+type schemaFields Schema
+
+/**
+ * Marshal
+ */
+func (s Schema) MarshalJSON() ([]byte, error) {
+  var ap interface{}
+  if s.NoAdditional {
+    ap = false
+  }else if s.AdditionalProperties != nil {
+    ap = s.AdditionalProperties
+  }
+  return json.Marshal(struct {
+    schemaFields
+    AdditionalProperties interface{} `json:"additionalProperties,omitempty"`
+  }{schemaFields(s), ap})
+}
+
+/**
+ * Unmarshal; additionalProperties may be a schema or a boolean
+ */
+func (s *Schema) UnmarshalJSON(data []byte) error {
+  v := struct {
+    *schemaFields
+    AdditionalProperties json.RawMessage `json:"additionalProperties"`
+  }{schemaFields:(*schemaFields)(s)}
+  err := json.Unmarshal(data, &v)
+  if err != nil {
+    return err
+  }
+  switch ap := strings.TrimSpace(string(v.AdditionalProperties)); ap {
+    case "", "null", "true":
+      // anything goes
+    case "false":
+      s.NoAdditional = true
+    default:
+      s.AdditionalProperties = &Schema{}
+      if err := json.Unmarshal(v.AdditionalProperties, s.AdditionalProperties); err != nil {
+        return err
+      }
+  }
+  return nil
+}
+
+/**
+ * Produces schemas for Go types. Named struct types are collected as
+ * components and referenced.
+ */
+type schemaGenerator struct {
+  components  map[string]*Schema
+  names       map[reflect.Type]string
+}
+
+/**
+ * Create a schema generator
+ */
+func newSchemaGenerator() *schemaGenerator {
+  return &schemaGenerator{make(map[string]*Schema), make(map[reflect.Type]string)}
+}
+
+/**
+ * Produce the schema for a type
+ */
+func (g *schemaGenerator) schema(t reflect.Type) *Schema {
+  for t.Kind() == reflect.Ptr {
+    t = t.Elem()
+  }
+
+  switch {
+    case t == typeTime:
+      return &Schema{Type:SchemaType{"string"}, Format:"date-time"}
+    case t == typeUUID:
+      return &Schema{Type:SchemaType{"string"}, Format:"uuid"}
+    case t == typeDuration:
+      return &Schema{Type:SchemaType{"integer"}, Format:"int64", Description:"A duration in nanoseconds"}
+    case t == reflect.TypeOf(json.RawMessage{}):
+      return &Schema{}
+  }
+
+  switch t.Kind() {
+    case reflect.Bool:
+      return &Schema{Type:SchemaType{"boolean"}}
+    case reflect.Int8, reflect.Int16, reflect.Int32:
+      return &Schema{Type:SchemaType{"integer"}, Format:"int32"}
+    case reflect.Int, reflect.Int64:
+      return &Schema{Type:SchemaType{"integer"}, Format:"int64"}
+    case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+      return &Schema{Type:SchemaType{"integer"}, Format:"int32", Minimum:floatp(0)}
+    case reflect.Uint, reflect.Uint64:
+      return &Schema{Type:SchemaType{"integer"}, Format:"int64", Minimum:floatp(0)}
+    case reflect.Float32:
+      return &Schema{Type:SchemaType{"number"}, Format:"float"}
+    case reflect.Float64:
+      return &Schema{Type:SchemaType{"number"}, Format:"double"}
+    case reflect.String:
+      return &Schema{Type:SchemaType{"string"}}
+    case reflect.Slice, reflect.Array:
+      if t.Elem().Kind() == reflect.Uint8 {
+        return &Schema{Type:SchemaType{"string"}, Format:"byte"}
+      }
+      return &Schema{Type:SchemaType{"array"}, Items:g.schema(t.Elem())}
+    case reflect.Map:
+      return &Schema{Type:SchemaType{"object"}, AdditionalProperties:g.schema(t.Elem())}
+    case reflect.Struct:
+      if t.Name() == "" {
+        return g.object(t)
+      }
+      return &Schema{Ref:"#/components/schemas/"+ g.component(t)}
+    default:
+      return &Schema{} // anything
+  }
+}
+
+/**
+ * Obtain the component name for a named struct, producing its schema if
+ * necessary
+ */
+func (g *schemaGenerator) component(t reflect.Type) string {
+  if n, ok := g.names[t]; ok {
+    return n
+  }
+  n := componentName(t.Name())
+  if _, ok := g.components[n]; ok {
+    p := t.PkgPath()
+    if x := strings.LastIndex(p, "/"); x >= 0 {
+      p = p[x+1:]
+    }
+    n = componentName(p +"."+ t.Name())
+    for i := 2; ; i++ {
+      if _, ok := g.components[n]; !ok {
+        break
+      }
+      n = componentName(fmt.Sprintf("%s.%s%d", p, t.Name(), i))
+    }
+  }
+  g.names[t] = n
+  g.components[n] = &Schema{} // reserve the name; the type may be recursive
+  *g.components[n] = *g.object(t)
+  return n
+}
+
+/**
+ * Produce a valid component name; names of instantiated generic types
+ * contain characters that are not permitted
+ */
+func componentName(n string) string {
+  return strings.Map(func(r rune) rune {
+    switch {
+      case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+        return r
+      default:
+        return '_'
+    }
+  }, n)
+}
+
+/**
+ * Produce an object schema for a struct
+ */
+func (g *schemaGenerator) object(t reflect.Type) *Schema {
+  s := &Schema{Type:SchemaType{"object"}, Properties:make(map[string]*Schema)}
+  g.fields(t, s, "json")
+  return s
+}
+
+/**
+ * Add struct fields to an object schema. Fields are named by the specified
+ * tag, or by the JSON rules when the tag is "json".
+ */
+func (g *schemaGenerator) fields(t reflect.Type, s *Schema, tag string) {
+  for i := 0; i < t.NumField(); i++ {
+    f := t.Field(i)
+    if f.PkgPath != "" && !f.Anonymous {
+      continue
+    }
+    n, opts := f.Tag.Get(tag), ""
+    if x := strings.Index(n, ","); x >= 0 {
+      n, opts = n[:x], n[x:]
+    }
+    if n == "-" && opts == "" {
+      continue
+    }
+    if f.Anonymous && n == "" {
+      ft := f.Type
+      if ft.Kind() == reflect.Ptr {
+        ft = ft.Elem()
+      }
+      if ft.Kind() == reflect.Struct {
+        g.fields(ft, s, tag)
+        continue
+      }
+    }
+    if n == "" {
+      if tag != "json" {
+        continue
+      }
+      n = f.Name
+    }
+    p := g.schema(f.Type)
+    applyValidation(p, f)
+    if d, ok := f.Tag.Lookup("default"); ok && p.Ref == "" {
+      p.Default = d
+    }
+    s.Properties[n] = p
+    if fieldRequired(f) {
+      s.Required = append(s.Required, n)
+    }
+  }
+}
+
+/**
+ * Determine if a field's validation rules require it
+ */
+func fieldRequired(f reflect.StructField) bool {
+  for _, r := range parseRules(f.Tag.Get("validate")) {
+    if r.name == "dive" {
+      break
+    }
+    if r.name == "required" {
+      return true
+    }
+  }
+  return false
+}
+
+/**
+ * Describe a field's validation rules in its schema, to the extent they
+ * can be expressed
+ */
+func applyValidation(s *Schema, f reflect.StructField) {
+  if s.Ref != "" {
+    return // keywords alongside a reference would not be portable
+  }
+  for _, r := range parseRules(f.Tag.Get("validate")) {
+    switch r.name {
+      case "dive":
+        return
+      case "min", "max", "len":
+        n, err := strconv.ParseFloat(r.param, 64)
+        if err != nil {
+          continue
+        }
+        switch {
+          case s.Type.Has("integer") || s.Type.Has("number"):
+            if r.name != "max" {
+              s.Minimum = floatp(n)
+            }
+            if r.name != "min" {
+              s.Maximum = floatp(n)
+            }
+          case s.Type.Has("string"):
+            if r.name != "max" {
+              s.MinLength = intp(int(n))
+            }
+            if r.name != "min" {
+              s.MaxLength = intp(int(n))
+            }
+          case s.Type.Has("array"):
+            if r.name != "max" {
+              s.MinItems = intp(int(n))
+            }
+            if r.name != "min" {
+              s.MaxItems = intp(int(n))
+            }
+        }
+      case "enum":
+        for _, e := range strings.Split(r.param, "|") {
+          if n, err := strconv.ParseFloat(e, 64); err == nil && (s.Type.Has("integer") || s.Type.Has("number")) {
+            s.Enum = append(s.Enum, n)
+          }else{
+            s.Enum = append(s.Enum, e)
+          }
+        }
+      case "regexp":
+        s.Pattern = r.param
+      case "email":
+        s.Format = "email"
+      case "url":
+        s.Format = "uri"
+      case "uuid":
+        s.Format = "uuid"
+    }
+  }
+}
+
+/**
+ * Pointer helpers
+ */
+func floatp(v float64) *float64 {
+  return &v
+}
+func intp(v int) *int {
+  return &v
+}
@@ -4,9 +4,13 @@ import (
   "io"
   "os"
   "fmt"
+  "sort"
   "time"
   "regexp"
+  "reflect"
   "strings"
+  "sync"
+  "net"
   "net/http"
 )
 
@@ -19,30 +23,61 @@ import (
  * Service config
  */
 type Config struct {
-  Name          string
-  Instance      string
-  Hostname      string
-  UserAgent     string
-  Endpoint      string
-  TraceRegexps  []*regexp.Regexp
-  EntityHandler EntityHandler
-  Debug         bool
+  Name               string
+  Instance           string
+  Hostname           string
+  UserAgent          string
+  Endpoint           string
+  TraceRegexps       []*regexp.Regexp
+  EntityHandler      EntityHandler
+  MaxEntitySize      int64        // the maximum request entity size in bytes, or zero for no limit
+  MaxInflateRatio    int          // the maximum ratio of decoded to encoded entity bytes, or zero for the default
+  ETags              ETagMode
+  ContentDigest      []string     // digest algorithms with which response entities are described by Content-Digest, if any
+  TrustedProxies     []*net.IPNet // proxies whose forwarding headers are honored
+  ForwardedHeader    string       // the header trusted proxies forward client addresses in, either Forwarded or, by default, X-Forwarded-For
+  TrustForwardedHost bool         // route requests by the host forwarded by a trusted proxy
+  Debug              bool
 }
 
 /**
  * A REST service
  */
 type Service struct {
-  name          string
-  instance      string
-  hostname      string
-  userAgent     string
-  port          string
-  router        *mux.Router
-  pipeline      Pipeline
-  traceRequests map[string]*regexp.Regexp
-  entityHandler EntityHandler
-  debug         bool
+  name               string
+  instance           string
+  hostname           string
+  userAgent          string
+  port               string
+  router             *mux.Router
+  pipeline           Pipeline
+  routed             Pipeline     // the service pipeline followed by routing
+  traceRequests      map[string]*regexp.Regexp
+  entityHandler      EntityHandler
+  maxEntitySize      int64
+  maxInflateRatio    int
+  etagMode           ETagMode
+  contentDigest      []string
+  trustedProxies     []*net.IPNet
+  forwardedHeader    string
+  trustForwardedHost bool
+  routes             map[*mux.Route]*routeInfo
+  notFound           []*Context
+  middlewareLock     sync.RWMutex // guards the middleware of every context
+  middlewareGen      int          // incremented when context middleware changes, so resolved pipelines are rebuilt
+  methodsLock        sync.Mutex
+  methods            []string     // the methods used by routes, resolved when first needed; nil when routes change
+  debug              bool
+}
+
+/**
+ * Information about a route registered through a context
+ */
+type routeInfo struct {
+  attrs   Attrs
+  handler Handler
+  input   reflect.Type // the type consumed by the handler, if known
+  output  reflect.Type // the type produced by the handler, if known
 }
 
 /**
@@ -56,8 +91,20 @@ func NewService(c Config) *Service {
   s.userAgent = c.UserAgent
   s.port = c.Endpoint
   s.router = mux.NewRouter()
+  s.routes = make(map[*mux.Route]*routeInfo)
   s.entityHandler = c.EntityHandler
+  s.maxEntitySize = c.MaxEntitySize
+  s.maxInflateRatio = c.MaxInflateRatio
+  s.etagMode = c.ETags
+  s.contentDigest = c.ContentDigest
+  s.trustedProxies = c.TrustedProxies
+  s.forwardedHeader = http.CanonicalHeaderKey(c.ForwardedHeader)
+  s.trustForwardedHost = c.TrustForwardedHost
+  s.routed = s.pipeline.Add(HandlerFunc(s.routeRequest))
   
+  if s.forwardedHeader == "" {
+    s.forwardedHeader = "X-Forwarded-For"
+  }
   if c.Name == "" {
     s.name = "service"
   }else{
@@ -82,7 +129,7 @@ func NewService(c Config) *Service {
  * Create a context
  */
 func (s *Service) Context() *Context {
-  return newContext(s, s.router)
+  return newContext(s, "", s.router)
 }
 
 /**
@@ -96,11 +143,23 @@ func (s *Service) Subrouter(p string) *mux.Router {
  * Create a context scoped under a base path
  */
 func (s *Service) ContextWithBasePath(p string) *Context {
-  return newContext(s, s.router.PathPrefix(p).Subrouter())
+  return newContext(s, p, s.router.PathPrefix(p).Subrouter())
 }
 
 /**
- * Attach a handler to the service pipeline
+ * Register information about a route
+ */
+func (s *Service) register(r *mux.Route, a Attrs, h Handler) {
+  in, out, _ := handlerTypes(h)
+  s.routes[r] = &routeInfo{a, h, in, out}
+  s.methodsLock.Lock()
+  s.methods = nil
+  s.methodsLock.Unlock()
+}
+
+/**
+ * Attach a handler to the service pipeline. Handlers should be attached
+ * before the service begins handling requests.
  */
 func (s *Service) Use(h ...Handler) {
   if h != nil {
@@ -108,13 +167,20 @@ func (s *Service) Use(h ...Handler) {
       s.pipeline = s.pipeline.Add(e)
     }
   }
+  s.routed = s.pipeline.Add(HandlerFunc(s.routeRequest))
 }
 
 /**
  * Run the service (this blocks forever)
  */
 func (s *Service) Run() error {
-  s.pipeline = s.pipeline.Add(HandlerFunc(s.routeRequest))
+  conflicts, err := s.CheckRoutes()
+  if err != nil {
+    return err
+  }
+  for _, e := range conflicts {
+    alt.Errorf("%s: %v", s.name, e)
+  }
   
   server := &http.Server{
     Addr: s.port,
@@ -128,27 +194,37 @@ func (s *Service) Run() error {
 }
 
 /**
- * Display all routes in the service
+ * Display all routes in the service and their attributes
  */
 func (s *Service) DumpRoutes(w io.Writer) error {
-  return s.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
-    p, err := route.GetPathTemplate()
-    if err != nil {
-      return err
-    }
-    fmt.Fprintf(w, "  %v", p)
+  routes, err := s.Routes()
+  if err != nil {
+    return err
+  }
+  for _, e := range routes {
+    fmt.Fprintf(w, "  %v", e)
     fmt.Fprintln(w)
-    return nil
-  })
+    attrs := describeAttrs(e.Attrs)
+    keys := make([]string, 0, len(attrs))
+    for k, _ := range attrs {
+      keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    for _, k := range keys {
+      fmt.Fprintf(w, "      %s: %v", k, attrs[k])
+      fmt.Fprintln(w)
+    }
+  }
   return nil
 }
 
 /**
- * Request handler
+ * Request handler. The service pipeline is followed by routing, so a
+ * service can be served, or mounted in another, without being run.
  */
 func (s *Service) ServeHTTP(rsp http.ResponseWriter, req *http.Request) {
-  wreq := newRequest(req)
-  res, err := s.pipeline.Next(rsp, wreq)
+  wreq := newRequest(s, req)
+  res, err := s.routed.Next(rsp, wreq)
   if res != nil || err != nil {
     s.sendResponse(rsp, wreq, res, err)
   }
@@ -156,9 +232,20 @@ func (s *Service) ServeHTTP(rsp http.ResponseWriter, req *http.Request) {
 
 /**
  * Default (routing) request handler; this is a bit weird, the context will
- * handle the result, so we return nothing from here
+ * handle the result, so we return nothing from here unless no route could
+ * handle the request
  */
 func (s *Service) routeRequest(rsp http.ResponseWriter, req *Request, pln Pipeline) (interface{}, error) {
+  s.forwardHost(req)
+  var match mux.RouteMatch
+  if matched := s.router.Match(req.Request, &match); !matched || match.MatchErr != nil {
+    if allowed := s.allowedMethods(req.Request); allowed != nil {
+      return s.routeUnmatchedMethod(rsp, req, allowed)
+    }
+    if !matched { // otherwise a router has its own not-found handler
+      return s.routeNotFound(rsp, req)
+    }
+  }
   s.router.ServeHTTP(rsp, req.Request)
   return nil, nil
 }
@@ -188,13 +275,22 @@ func (s *Service) sendError(rsp http.ResponseWriter, req *Request, err error) {
       r = cerr.Status
       h = cerr.Headers
       c = cerr.Cause
-      alt.Errorf("%s: [%v] %v", s.name, req.Id, cerr.Cause)
+      if r >= 400 {
+        alt.Errorf("%s: [%v] %v", s.name, req.Id, cerr.Cause)
+      }
     default:
       r = http.StatusInternalServerError
       c = basicError{http.StatusInternalServerError, err.Error()}
       alt.Errorf("%s: [%v] %v", s.name, req.Id, err)
   }
   
+  if c == nil {
+    c = basicError{r, http.StatusText(r)}
+  }
+  if cerr, ok := err.(*Error); ok && cerr.Detail != nil {
+    c = detailError{r, c.Error(), cerr.Detail}
+  }
+  
   if req.Accepts("text/html") {
     s.sendEntity(rsp, req, r, h, htmlError(r, h, c))
   }else{
@@ -217,10 +313,12 @@ func (s *Service) sendEntity(rsp http.ResponseWriter, req *Request, status int,
   }
   
   var err error
-  if s.entityHandler != nil {
-    err = s.entityHandler(rsp, req, status, content)
+  if status == http.StatusNotModified {
+    rsp.WriteHeader(status) // no entity is permitted
+  }else if status == http.StatusOK && (s.etagMode != ETagNone || hasValidators(content)) {
+    err = s.sendConditionalEntity(rsp, req, status, content)
   }else{
-    err = DefaultEntityHandler(rsp, req, status, content)
+    err = s.writeEntity(rsp, req, status, content)
   }
   if err != nil {
     alt.Errorf("%s: %v", s.name, err)
@@ -229,6 +327,30 @@ func (s *Service) sendEntity(rsp http.ResponseWriter, req *Request, status int,
   
 }
 
+/**
+ * Write an entity using the configured entity handler
+ */
+func (s *Service) writeEntity(rsp http.ResponseWriter, req *Request, status int, content interface{}) error {
+  if s.entityHandler != nil {
+    return s.entityHandler(rsp, req, status, content)
+  }else{
+    return DefaultEntityHandler(rsp, req, status, content)
+  }
+}
+
+/**
+ * Determine if content describes its own validators
+ */
+func hasValidators(content interface{}) bool {
+  if _, ok := content.(ETagger); ok {
+    return true
+  }
+  if _, ok := content.(LastModifier); ok {
+    return true
+  }
+  return false
+}
+
 /**
  * Produce a HTML error entity
  */
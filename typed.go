@@ -0,0 +1,155 @@
+package rest
+
+import (
+  "context"
+  "reflect"
+  "io/ioutil"
+  "net/http"
+  "encoding/json"
+)
+
+/**
+ * Implemented by handlers which describe the types they consume and produce
+ */
+type TypedHandler interface {
+  Handler
+  InputType()(reflect.Type)
+  OutputType()(reflect.Type)
+}
+
+/**
+ * A typed handler function
+ */
+type TypedFunc[In, Out any] func(context.Context, *Request, In)(Out, error)
+
+/**
+ * A typed handler
+ */
+type typedHandler[In, Out any] struct {
+  f TypedFunc[In, Out]
+}
+
+/**
+ * Adapt a typed function to a handler. Before the function is invoked its
+ * input is bound from the request and validated: structs, and pointers to
+ * structs, are bound by Bind; any other input type is unmarshaled from a
+ * JSON request entity. The output is sent through the service's entity
+ * handler like any other result. Typed handlers are terminal, they do not
+ * continue the pipeline.
+ */
+func Typed[In, Out any](f func(context.Context, *Request, In)(Out, error)) TypedHandler {
+  return typedHandler[In, Out]{f}
+}
+
+/**
+ * Input type
+ */
+func (h typedHandler[In, Out]) InputType() reflect.Type {
+  return reflect.TypeOf((*In)(nil)).Elem()
+}
+
+/**
+ * Output type
+ */
+func (h typedHandler[In, Out]) OutputType() reflect.Type {
+  return reflect.TypeOf((*Out)(nil)).Elem()
+}
+
+/**
+ * Serve a request
+ */
+func (h typedHandler[In, Out]) ServeRequest(rsp http.ResponseWriter, req *Request, pln Pipeline) (interface{}, error) {
+  var in In
+  err := decodeInput(req, &in)
+  if err != nil {
+    return nil, err
+  }
+
+  out, err := h.f(req.Context(), req, in)
+  if err != nil {
+    return nil, err
+  }
+
+  if v := reflect.ValueOf(out); !v.IsValid() {
+    return nil, nil
+  }else if k := v.Kind(); (k == reflect.Ptr || k == reflect.Interface || k == reflect.Map || k == reflect.Slice) && v.IsNil() {
+    return nil, nil // no entity rather than a typed nil
+  }
+  return out, nil
+}
+
+/**
+ * Bind and validate handler input. Structs with binding tags are bound with
+ * Bind; other input, including structs without any binding tags, is
+ * decoded from the JSON request entity.
+ */
+func decodeInput(req *Request, dst interface{}) error {
+  v := reflect.ValueOf(dst).Elem()
+
+  t := v.Type()
+  if t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct {
+    v.Set(reflect.New(t.Elem()))
+    v, t = v.Elem(), t.Elem()
+  }
+
+  if t.Kind() == reflect.Struct && hasBindingTags(t) {
+    if err := Bind(req, v.Addr().Interface()); err != nil {
+      return err
+    }
+    return Validate(v.Addr().Interface())
+  }
+
+  if req.Body == nil || req.Body == http.NoBody {
+    return Validate(v.Addr().Interface())
+  }
+  data, err := ioutil.ReadAll(req.Body)
+  if rerr, ok := err.(*Error); ok {
+    return rerr
+  }else if err != nil {
+    return NewErrorf(http.StatusBadRequest, "Could not read request entity: %v", err)
+  }
+  if err := req.VerifyEntityDigest(data); err != nil {
+    return err
+  }
+  if len(data) > 0 {
+    if err = json.Unmarshal(data, v.Addr().Interface()); err != nil {
+      return NewErrorf(http.StatusBadRequest, "Could not unmarshal request entity: %v", err)
+    }
+  }
+  return Validate(v.Addr().Interface())
+}
+
+/**
+ * Determine if a struct has any field tagged for binding
+ */
+func hasBindingTags(t reflect.Type) bool {
+  if hasTag(t, "json") {
+    return true
+  }
+  for _, e := range bindSources {
+    if hasTag(t, e) {
+      return true
+    }
+  }
+  return false
+}
+
+/**
+ * Obtain the types consumed and produced by a handler, if it describes them.
+ * The final stage of a pipeline is considered.
+ */
+func handlerTypes(h Handler) (reflect.Type, reflect.Type, bool) {
+  for {
+    if p, ok := h.(Pipeline); ok && len(p) > 0 {
+      h = p[len(p)-1]
+    }else if c, ok := h.(*contextHandler); ok {
+      h = c.handler
+    }else{
+      break
+    }
+  }
+  if t, ok := h.(TypedHandler); ok {
+    return t.InputType(), t.OutputType(), true
+  }
+  return nil, nil, false
+}
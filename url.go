@@ -0,0 +1,75 @@
+package rest
+
+import (
+  "fmt"
+  "strings"
+  "net/url"
+)
+
+/**
+ * Route naming attributes
+ */
+const (
+  ATTR_ROUTE_NAME = "rest.route_name" // a string naming the route, equivalent to naming the route it returns
+)
+
+/**
+ * Build the URL of a named route from variable name and value pairs. The
+ * URL includes any base path the route was registered under. Unless the
+ * route matches a host, the URL is relative to the service's host.
+ */
+func (s *Service) URL(name string, pairs ...string) (*url.URL, error) {
+  r := s.router.Get(name)
+  if r == nil {
+    return nil, fmt.Errorf("No such route: %v", name)
+  }
+  u, err := r.URL(pairs...)
+  if err != nil {
+    return nil, fmt.Errorf("Could not build URL for route %v: %v", name, err)
+  }
+  return u, nil
+}
+
+/**
+ * Build the absolute URL of a named route, as it is addressed by the client
+ * that made the provided request. The scheme and host are those the client
+ * used, which may differ from the service's when it is behind a proxy.
+ */
+func (s *Service) AbsoluteURL(req *Request, name string, pairs ...string) (*url.URL, error) {
+  u, err := s.URL(name, pairs...)
+  if err != nil {
+    return nil, err
+  }
+  if u.Scheme == "" {
+    u.Scheme = req.Scheme()
+  }
+  if u.Host == "" {
+    u.Host = req.ExternalHost()
+  }
+  return u, nil
+}
+
+/**
+ * Obtain the scheme the client used to make the request. The scheme
+ * forwarded by a proxy is used if the proxy is trusted.
+ */
+func (r *Request) Scheme() string {
+  if p := r.forwardedParam("proto", "X-Forwarded-Proto"); p != "" {
+    return strings.ToLower(p)
+  }
+  if r.TLS != nil {
+    return "https"
+  }
+  return "http"
+}
+
+/**
+ * Obtain the host the client addressed with the request. The host
+ * forwarded by a proxy is used if the proxy is trusted.
+ */
+func (r *Request) ExternalHost() string {
+  if h := r.forwardedParam("host", "X-Forwarded-Host"); h != "" {
+    return h
+  }
+  return r.Host
+}
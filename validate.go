@@ -0,0 +1,476 @@
+package rest
+
+import (
+  "fmt"
+  "sync"
+  "regexp"
+  "reflect"
+  "strconv"
+  "strings"
+  "net/url"
+  "net/http"
+  "net/mail"
+  "unicode/utf8"
+)
+
+/**
+ * A validator checks a value against a rule. The parameter is the text
+ * following the '=' in the rule, if any. Pointers are dereferenced before
+ * validators are invoked.
+ */
+type Validator func(v reflect.Value, param string)(error)
+
+var (
+  validatorsLock  sync.RWMutex
+  validators      = map[string]Validator{
+    "min":    validateMin,
+    "max":    validateMax,
+    "len":    validateLen,
+    "regexp": validateRegexp,
+    "enum":   validateEnum,
+    "email":  validateEmail,
+    "url":    validateURL,
+    "uuid":   validateUUID,
+  }
+  regexps         sync.Map
+)
+
+/**
+ * Register a custom validator which can then be used in validate tags by
+ * name. Registering a validator with the name of an existing validator
+ * replaces it.
+ */
+func RegisterValidator(name string, v Validator) {
+  validatorsLock.Lock()
+  defer validatorsLock.Unlock()
+  validators[name] = v
+}
+
+/**
+ * Obtain a validator
+ */
+func lookupValidator(name string) (Validator, bool) {
+  validatorsLock.RLock()
+  defer validatorsLock.RUnlock()
+  v, ok := validators[name]
+  return v, ok
+}
+
+/**
+ * Validate a struct according to the validate tags on its fields. A tag is
+ * a comma-separated list of rules, for example:
+ *
+ *   validate:"required,min=1,max=100"
+ *
+ * The rules are: required, min=n, max=n, len=n, enum=a|b|c, email, url,
+ * uuid or uuid=version, regexp=expr and any registered with
+ * RegisterValidator. Because expressions may contain commas, regexp must
+ * be the last rule in a tag. Min, max and len compare numbers by value and
+ * strings, slices and maps by length. Rules other than required are not
+ * applied to nil pointers or to empty strings, slices and maps. Rules
+ * following dive are applied to each element of a slice, array or map
+ * instead of the field itself. Nested structs are always validated.
+ *
+ * Fields are named in errors by their JSON, path, query, header or form
+ * name, in that order of preference. If any field is invalid an *Error with
+ * the status 422 is returned whose Detail is the FieldErrors describing
+ * every violation. A rule which is not known is a programming error rather
+ * than a problem with the request, so a plain error is returned for it
+ * instead, which is answered with 500 Internal Server Error. Values which
+ * are not structs or pointers to structs are not validated.
+ */
+func Validate(v interface{}) error {
+  rv := reflect.ValueOf(v)
+  for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+    if rv.IsNil() {
+      return nil
+    }
+    rv = rv.Elem()
+  }
+  if rv.Kind() != reflect.Struct {
+    return nil
+  }
+
+  var st validation
+  validateStruct(rv, "", &st)
+  if st.err != nil {
+    return st.err
+  }
+  if len(st.errs) > 0 {
+    return NewErrorf(http.StatusUnprocessableEntity, "Invalid request: %v", st.errs).SetDetail(st.errs)
+  }
+  return nil
+}
+
+/**
+ * Validation state
+ */
+type validation struct {
+  errs  FieldErrors
+  err   error // a problem with the rules themselves rather than the value
+}
+
+/**
+ * Validate struct fields
+ */
+func validateStruct(v reflect.Value, path string, st *validation) {
+  t := v.Type()
+  for i := 0; i < t.NumField(); i++ {
+    f := t.Field(i)
+    if f.PkgPath != "" && !f.Anonymous {
+      continue // unexported
+    }
+    fv := v.Field(i)
+    if f.Anonymous && !hasAnyTag(f) {
+      if s, ok := indirect(fv); ok && s.Kind() == reflect.Struct {
+        validateStruct(s, path, st)
+      }
+      continue
+    }
+    name := fieldName(f)
+    if name == "-" {
+      continue
+    }
+    if path != "" {
+      name = path +"."+ name
+    }
+    validateValue(fv, name, parseRules(f.Tag.Get("validate")), st)
+  }
+}
+
+/**
+ * Validate a value against rules
+ */
+func validateValue(v reflect.Value, name string, rules []rule, st *validation) {
+  for _, r := range rules {
+    if r.name == "required" || r.name == "dive" {
+      continue
+    }
+    if _, ok := lookupValidator(r.name); !ok {
+      if st.err == nil {
+        st.err = fmt.Errorf("Unknown validation rule for %s: %s", name, r.name)
+      }
+      return
+    }
+  }
+
+  var dive []rule
+  for i, r := range rules {
+    if r.name == "dive" {
+      dive, rules = rules[i+1:], rules[:i]
+      break
+    }
+  }
+
+  iv, ok := indirect(v)
+  if !ok || iv.IsZero() {
+    for _, r := range rules {
+      if r.name == "required" {
+        st.errs = append(st.errs, FieldError{Field:name, Message:"is required"})
+        return
+      }
+    }
+    if !ok {
+      return
+    }
+  }
+
+  if !isEmpty(iv) {
+    for _, r := range rules {
+      if r.name == "required" {
+        continue
+      }
+      f, _ := lookupValidator(r.name)
+      if err := f(iv, r.param); err != nil {
+        st.errs = append(st.errs, FieldError{Field:name, Message:err.Error()})
+      }
+    }
+  }
+
+  switch iv.Kind() {
+    case reflect.Struct:
+      if iv.Type() != typeTime && iv.Type() != typeUUID {
+        validateStruct(iv, name, st)
+      }
+    case reflect.Slice, reflect.Array:
+      if iv.Type() == typeUUID {
+        break
+      }
+      for i := 0; i < iv.Len(); i++ {
+        validateElement(iv.Index(i), fmt.Sprintf("%s[%d]", name, i), dive, st)
+      }
+    case reflect.Map:
+      for _, k := range iv.MapKeys() {
+        validateElement(iv.MapIndex(k), fmt.Sprintf("%s[%v]", name, k.Interface()), dive, st)
+      }
+  }
+}
+
+/**
+ * Validate a collection element; elements are only visited if there are
+ * rules to apply to them or they may contain structs
+ */
+func validateElement(v reflect.Value, name string, rules []rule, st *validation) {
+  if len(rules) > 0 || mayContainStruct(v.Type()) {
+    validateValue(v, name, rules, st)
+  }
+}
+
+/**
+ * Determine if values of a type may contain structs that need validation
+ */
+func mayContainStruct(t reflect.Type) bool {
+  for t.Kind() == reflect.Ptr {
+    t = t.Elem()
+  }
+  switch t.Kind() {
+    case reflect.Struct:
+      return t != typeTime
+    case reflect.Slice, reflect.Array, reflect.Map:
+      return t != typeUUID && mayContainStruct(t.Elem())
+    case reflect.Interface:
+      return true
+    default:
+      return false
+  }
+}
+
+/**
+ * Determine if a value is empty for the purpose of skipping rules; numbers
+ * and booleans are never empty
+ */
+func isEmpty(v reflect.Value) bool {
+  switch v.Kind() {
+    case reflect.String, reflect.Slice, reflect.Map:
+      return v.Len() == 0
+    case reflect.Struct, reflect.Array:
+      return v.IsZero()
+    default:
+      return false
+  }
+}
+
+/**
+ * Dereference pointers and interfaces; false is returned if the value is nil
+ */
+func indirect(v reflect.Value) (reflect.Value, bool) {
+  for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+    if v.IsNil() {
+      return v, false
+    }
+    v = v.Elem()
+  }
+  return v, true
+}
+
+/**
+ * Obtain the name a field is described by
+ */
+func fieldName(f reflect.StructField) string {
+  for _, e := range append([]string{"json"}, bindSources...) {
+    if n := f.Tag.Get(e); n != "" {
+      if x := strings.Index(n, ","); x >= 0 {
+        n = n[:x]
+      }
+      if n == "-" && e == "json" {
+        continue // may be bound from elsewhere
+      }
+      if n != "" {
+        return n
+      }
+    }
+  }
+  return f.Name
+}
+
+/**
+ * A validation rule
+ */
+type rule struct {
+  name  string
+  param string
+}
+
+/**
+ * Parse validation rules
+ */
+func parseRules(tag string) []rule {
+  var rules []rule
+  for tag != "" {
+    var e string
+    if strings.HasPrefix(tag, "regexp=") {
+      e, tag = tag, "" // the expression consumes the rest of the tag
+    }else if x := strings.Index(tag, ","); x >= 0 {
+      e, tag = tag[:x], tag[x+1:]
+    }else{
+      e, tag = tag, ""
+    }
+    if e = strings.TrimSpace(e); e == "" {
+      continue
+    }
+    if x := strings.Index(e, "="); x >= 0 {
+      rules = append(rules, rule{e[:x], e[x+1:]})
+    }else{
+      rules = append(rules, rule{e, ""})
+    }
+  }
+  return rules
+}
+
+/**
+ * Obtain the size of a value for min, max and len. Numbers are compared by
+ * value and everything else by length.
+ */
+func validationSize(v reflect.Value) (float64, bool, error) {
+  switch v.Kind() {
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+      return float64(v.Int()), true, nil
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+      return float64(v.Uint()), true, nil
+    case reflect.Float32, reflect.Float64:
+      return v.Float(), true, nil
+    case reflect.String:
+      return float64(utf8.RuneCountInString(v.String())), false, nil
+    case reflect.Slice, reflect.Array, reflect.Map:
+      return float64(v.Len()), false, nil
+    default:
+      return 0, false, fmt.Errorf("cannot be measured")
+  }
+}
+
+/**
+ * Compare a value's size to a limit
+ */
+func validateSize(v reflect.Value, param string, check func(n, l float64)(bool), numeric, length string) error {
+  l, err := strconv.ParseFloat(param, 64)
+  if err != nil {
+    return fmt.Errorf("invalid rule parameter: %q", param)
+  }
+  n, isnum, err := validationSize(v)
+  if err != nil {
+    return err
+  }
+  if !check(n, l) {
+    if isnum {
+      return fmt.Errorf(numeric, param)
+    }else{
+      return fmt.Errorf(length, param)
+    }
+  }
+  return nil
+}
+
+/**
+ * Minimum
+ */
+func validateMin(v reflect.Value, param string) error {
+  return validateSize(v, param, func(n, l float64) bool { return n >= l }, "must be at least %s", "must have a length of at least %s")
+}
+
+/**
+ * Maximum
+ */
+func validateMax(v reflect.Value, param string) error {
+  return validateSize(v, param, func(n, l float64) bool { return n <= l }, "must be at most %s", "must have a length of at most %s")
+}
+
+/**
+ * Exact length
+ */
+func validateLen(v reflect.Value, param string) error {
+  return validateSize(v, param, func(n, l float64) bool { return n == l }, "must be %s", "must have a length of %s")
+}
+
+/**
+ * Regular expression
+ */
+func validateRegexp(v reflect.Value, param string) error {
+  var r *regexp.Regexp
+  if e, ok := regexps.Load(param); ok {
+    r = e.(*regexp.Regexp)
+  }else{
+    var err error
+    r, err = regexp.Compile(param)
+    if err != nil {
+      return fmt.Errorf("invalid rule expression: %v", err)
+    }
+    regexps.Store(param, r)
+  }
+  if v.Kind() != reflect.String {
+    return fmt.Errorf("must be a string")
+  }
+  if !r.MatchString(v.String()) {
+    return fmt.Errorf("must match %s", param)
+  }
+  return nil
+}
+
+/**
+ * Enumerated values
+ */
+func validateEnum(v reflect.Value, param string) error {
+  s := fmt.Sprint(v.Interface())
+  opts := strings.Split(param, "|")
+  for _, e := range opts {
+    if s == e {
+      return nil
+    }
+  }
+  return fmt.Errorf("must be one of: %s", strings.Join(opts, ", "))
+}
+
+/**
+ * Email address
+ */
+func validateEmail(v reflect.Value, param string) error {
+  if v.Kind() != reflect.String {
+    return fmt.Errorf("must be a string")
+  }
+  a, err := mail.ParseAddress(v.String())
+  if err != nil || a.Address != v.String() {
+    return fmt.Errorf("must be a valid email address")
+  }
+  return nil
+}
+
+/**
+ * Absolute URL
+ */
+func validateURL(v reflect.Value, param string) error {
+  if v.Kind() != reflect.String {
+    return fmt.Errorf("must be a string")
+  }
+  u, err := url.ParseRequestURI(v.String())
+  if err != nil || u.Scheme == "" || u.Host == "" {
+    return fmt.Errorf("must be a valid absolute URL")
+  }
+  return nil
+}
+
+/**
+ * UUID, optionally of a specific version
+ */
+func validateUUID(v reflect.Value, param string) error {
+  var u UUID
+  if v.Type() == typeUUID {
+    u = v.Interface().(UUID)
+  }else if v.Kind() == reflect.String {
+    var err error
+    u, err = ParseUUID(v.String())
+    if err != nil {
+      return fmt.Errorf("must be a valid UUID")
+    }
+  }else{
+    return fmt.Errorf("must be a UUID")
+  }
+  if param != "" {
+    n, err := strconv.Atoi(param)
+    if err != nil {
+      return fmt.Errorf("invalid rule parameter: %q", param)
+    }
+    if u.Version() != n {
+      return fmt.Errorf("must be a version %d UUID", n)
+    }
+  }
+  return nil
+}
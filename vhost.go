@@ -0,0 +1,82 @@
+package rest
+
+import (
+  "fmt"
+  "strings"
+  "net/http"
+)
+
+import (
+  "github.com/gorilla/mux"
+)
+
+/**
+ * Virtual host attributes
+ */
+const (
+  ATTR_HOST_VARS = "rest.host_vars" // a map[string]string of variables captured from the request host
+)
+
+/**
+ * Create a context for requests to a host. The host may be a name, like
+ * api.example.com, or a template which captures variables, like
+ * {tenant}.example.com; a label of * matches any single label and is
+ * captured as the variable subdomain (and subdomain2, and so on, when
+ * there is more than one). Captured variables are available as route
+ * variables and in the request attributes as ATTR_HOST_VARS.
+ *
+ * Each host context has its own middleware and not-found handler. Hosts are
+ * matched in the order their contexts are created, before any routes which
+ * are registered later without a host.
+ */
+func (s *Service) ContextWithHost(host string) *Context {
+  t := hostTemplate(host)
+  c := newContext(s, "", s.router.Host(t).Subrouter())
+  c.host = mux.NewRouter().Host(t)
+  return c
+}
+
+/**
+ * Convert a host with wildcard labels to a route template
+ */
+func hostTemplate(h string) string {
+  labels := strings.Split(h, ".")
+  n := 0
+  for i, e := range labels {
+    if e == "*" {
+      n++
+      if n == 1 {
+        labels[i] = "{subdomain}"
+      }else{
+        labels[i] = fmt.Sprintf("{subdomain%d}", n)
+      }
+    }
+  }
+  return strings.Join(labels, ".")
+}
+
+/**
+ * Determine if a request is to a context's host; contexts without a host
+ * accept requests to any host
+ */
+func (c *Context) matchesHost(req *http.Request) (bool, map[string]string) {
+  if c.host == nil {
+    return true, nil
+  }
+  var match mux.RouteMatch
+  if !c.host.Match(req, &match) {
+    return false, nil
+  }
+  return true, match.Vars
+}
+
+/**
+ * Use the host forwarded by a proxy as the request host, if the service is
+ * configured to and the proxy is trusted, so that routing uses the host the
+ * client addressed
+ */
+func (s *Service) forwardHost(req *Request) {
+  if s.trustForwardedHost {
+    req.Host = req.ExternalHost()
+  }
+}